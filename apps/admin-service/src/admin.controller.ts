@@ -381,3 +381,29 @@ export const userNotifications = async (
     return next(error);
   }
 };
+
+// Get platform-wide daily view/cart/wishlist/purchase trends
+export const getAnalyticsTrends = async (
+  req: Request,
+  res: Response,
+  next: NextFunction
+) => {
+  try {
+    const days = Math.min(parseInt(req.query.days as string) || 30, 365);
+    const since = new Date(Date.now() - days * 24 * 60 * 60 * 1000);
+
+    // Unique users can't be summed across shops, so only the counters
+    const buckets = await prisma.shopAnalyticsDaily.groupBy({
+      by: ['day'],
+      where: { day: { gte: since } },
+      _sum: { views: true, cartAdds: true, wishlistAdds: true, purchases: true },
+      orderBy: { day: 'asc' },
+    });
+
+    const trends = buckets.map(({ day, _sum }) => ({ day, ..._sum }));
+
+    return res.status(200).json({ success: true, trends });
+  } catch (error) {
+    return next(error);
+  }
+};
@@ -11,6 +11,7 @@ import {
   userNotifications,
   getAllCustomizations,
   getAdmin,
+  getAnalyticsTrends,
 } from './admin.controller';
 import isAuthenticated from '@packages/error-handler/isAuthenticated';
 import { isAdmin } from '@packages/error-handler/authorizeRoles';
@@ -34,5 +35,6 @@ router.get('/get-admin-orders', isAuthenticated, isAdmin, getAdminOrders);
 router.get('/get-all-products', isAuthenticated, isAdmin, getAllProducts);
 router.get('/all-sellers', isAuthenticated, isAdmin, getAllCustomizations);
 router.get('/logged-in-admin', isAuthenticated, isAdmin, getAdmin);
+router.get('/get-analytics-trends', isAuthenticated, isAdmin, getAnalyticsTrends);
 
 export default router;
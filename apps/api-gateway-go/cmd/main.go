@@ -27,7 +27,10 @@ func main() {
 	log.Printf("Configuration loaded: Port=%s", cfg.Port)
 
 	// Create and start server
-	srv := server.NewServer(cfg)
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
 
 	go func() {
 		if err := srv.Start(); err != nil {
@@ -44,7 +47,11 @@ func main() {
 
 	log.Println("Shutting down gracefully...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Fail readiness first and keep serving until the load balancer has caught up
+	srv.BeginShutdown()
+	time.Sleep(time.Duration(cfg.ShutdownPreStopDelay) * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout)*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
@@ -0,0 +1,326 @@
+// Command replay sends traffic captured by the gateway (GATEWAY_CAPTURE_DIR)
+// to a target upstream and reports status and body differences.
+//
+//	go run ./cmd/replay -target http://localhost:6002 captures/products-2024-05-01.jsonl
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eshop/api-gateway-go/internal/capture"
+)
+
+// maxDiffs caps how many body differences are printed per request
+const maxDiffs = 10
+
+// skippedHeaders are not replayed: redacted, hop-by-hop, or set by the client
+var skippedHeaders = map[string]bool{
+	"Accept-Encoding":   true, // Keep responses uncompressed so bodies can be compared
+	"Connection":        true,
+	"Content-Length":    true,
+	"Host":              true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+type headerFlags []string
+
+func (h *headerFlags) String() string     { return strings.Join(*h, ", ") }
+func (h *headerFlags) Set(v string) error { *h = append(*h, v); return nil }
+
+func main() {
+	target := flag.String("target", "", "Base URL of the upstream to replay against, e.g. http://localhost:6002")
+	route := flag.String("route", "", "Only replay entries captured on this route")
+	ignore := flag.String("ignore", "", "Comma separated JSON fields to ignore when comparing bodies, e.g. createdAt,updatedAt")
+	timeout := flag.Duration("timeout", 10*time.Second, "Timeout per request")
+	limit := flag.Int("limit", 0, "Stop after this many requests (0 for all)")
+	verbose := flag.Bool("v", false, "Also print requests that matched")
+	var extraHeaders headerFlags
+	flag.Var(&extraHeaders, "header", "Header added to every request, e.g. \"Authorization: Bearer ...\" (repeatable)")
+	flag.Parse()
+
+	if *target == "" || flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: replay -target URL [flags] capture.jsonl...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	header := make(http.Header)
+	for _, h := range extraHeaders {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			log.Fatalf("Invalid -header %q, expected \"Name: value\"", h)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	ignored := make(map[string]bool)
+	for _, field := range strings.Split(*ignore, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			ignored[field] = true
+		}
+	}
+
+	r := &replayer{
+		target:  strings.TrimSuffix(*target, "/"),
+		header:  header,
+		ignored: ignored,
+		client: &http.Client{
+			Timeout: *timeout,
+			// Compare the upstream's own redirects rather than following them
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		verbose: *verbose,
+	}
+
+	var total, matched, differed, failed int
+	for _, path := range flag.Args() {
+		entries, err := readEntries(path)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", path, err)
+		}
+		for _, entry := range entries {
+			if *route != "" && entry.Route != *route {
+				continue
+			}
+			if *limit > 0 && total >= *limit {
+				break
+			}
+			total++
+
+			switch r.replay(entry) {
+			case resultMatched:
+				matched++
+			case resultDiffered:
+				differed++
+			default:
+				failed++
+			}
+		}
+	}
+
+	fmt.Printf("\nReplayed %d requests: %d matched, %d differed, %d failed\n", total, matched, differed, failed)
+	if differed > 0 || failed > 0 {
+		os.Exit(1)
+	}
+}
+
+type result int
+
+const (
+	resultMatched result = iota
+	resultDiffered
+	resultFailed
+)
+
+type replayer struct {
+	target  string
+	header  http.Header
+	ignored map[string]bool
+	client  *http.Client
+	verbose bool
+}
+
+func (r *replayer) replay(entry *capture.Entry) result {
+	label := fmt.Sprintf("%s %s (%s, %s)", entry.Request.Method, entry.Request.Path, entry.Route, entry.Time.Format(time.RFC3339))
+
+	url := r.target + entry.Request.Path
+	if entry.Request.Query != "" {
+		url += "?" + entry.Request.Query
+	}
+
+	var body io.Reader
+	switch {
+	case len(entry.Request.Body) > 0:
+		body = bytes.NewReader(entry.Request.Body)
+	case entry.Request.BodyText != "":
+		body = strings.NewReader(entry.Request.BodyText)
+	}
+
+	req, err := http.NewRequest(entry.Request.Method, url, body)
+	if err != nil {
+		fmt.Printf("FAIL  %s: %v\n", label, err)
+		return resultFailed
+	}
+	for name, value := range entry.Request.Headers {
+		if skippedHeaders[http.CanonicalHeaderKey(name)] || value == "[REDACTED]" {
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	for name, values := range r.header {
+		req.Header[name] = values
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		fmt.Printf("FAIL  %s: %v\n", label, err)
+		return resultFailed
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("FAIL  %s: reading body: %v\n", label, err)
+		return resultFailed
+	}
+
+	var diffs []string
+	if resp.StatusCode != entry.Response.Status {
+		diffs = append(diffs, fmt.Sprintf("status %d -> %d", entry.Response.Status, resp.StatusCode))
+	}
+	diffs = append(diffs, r.compareBodies(entry.Response, data)...)
+
+	if len(diffs) == 0 {
+		if r.verbose {
+			fmt.Printf("OK    %s\n", label)
+		}
+		return resultMatched
+	}
+
+	fmt.Printf("DIFF  %s\n", label)
+	for i, diff := range diffs {
+		if i == maxDiffs {
+			fmt.Printf("      ... and %d more\n", len(diffs)-maxDiffs)
+			break
+		}
+		fmt.Printf("      %s\n", diff)
+	}
+	return resultDiffered
+}
+
+// compareBodies diffs JSON structurally and text verbatim. Bodies that weren't
+// captured (binary, compressed or too large) are not compared.
+func (r *replayer) compareBodies(captured capture.Response, actual []byte) []string {
+	switch {
+	case len(captured.Body) > 0:
+		var want, got interface{}
+		if err := json.Unmarshal(captured.Body, &want); err != nil {
+			return []string{fmt.Sprintf("captured body is not JSON: %v", err)}
+		}
+		if err := json.Unmarshal(actual, &got); err != nil {
+			return []string{"body is no longer JSON"}
+		}
+		var diffs []string
+		r.diffJSON("", want, got, &diffs)
+		return diffs
+	case captured.BodyText != "":
+		if captured.BodyText != string(actual) {
+			return []string{"body text differs"}
+		}
+	}
+	return nil
+}
+
+func (r *replayer) diffJSON(path string, want, got interface{}, diffs *[]string) {
+	if s, ok := want.(string); ok && s == "[REDACTED]" {
+		return
+	}
+
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			*diffs = append(*diffs, fmt.Sprintf("body %s: object -> %s", displayPath(path), describe(got)))
+			return
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for key := range w {
+			keys = append(keys, key)
+		}
+		for key := range g {
+			if _, ok := w[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if r.ignored[key] {
+				continue
+			}
+			wantValue, inWant := w[key]
+			gotValue, inGot := g[key]
+			switch {
+			case !inGot:
+				*diffs = append(*diffs, fmt.Sprintf("body %s/%s: missing", displayPath(path), key))
+			case !inWant:
+				*diffs = append(*diffs, fmt.Sprintf("body %s/%s: unexpected %s", displayPath(path), key, describe(gotValue)))
+			default:
+				r.diffJSON(path+"/"+key, wantValue, gotValue, diffs)
+			}
+		}
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			*diffs = append(*diffs, fmt.Sprintf("body %s: array -> %s", displayPath(path), describe(got)))
+			return
+		}
+		if len(w) != len(g) {
+			*diffs = append(*diffs, fmt.Sprintf("body %s: length %d -> %d", displayPath(path), len(w), len(g)))
+		}
+		for i := 0; i < len(w) && i < len(g); i++ {
+			r.diffJSON(fmt.Sprintf("%s/%d", path, i), w[i], g[i], diffs)
+		}
+	default:
+		if !reflect.DeepEqual(want, got) {
+			*diffs = append(*diffs, fmt.Sprintf("body %s: %s -> %s", displayPath(path), describe(want), describe(got)))
+		}
+	}
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func describe(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	data, _ := json.Marshal(value)
+	if len(data) > 80 {
+		return string(data[:77]) + "..."
+	}
+	return string(data)
+}
+
+func readEntries(path string) ([]*capture.Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*capture.Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 8<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		entry := &capture.Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
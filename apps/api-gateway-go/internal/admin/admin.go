@@ -0,0 +1,271 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eshop/api-gateway-go/internal/apikey"
+	"github.com/eshop/api-gateway-go/internal/config"
+	"github.com/eshop/api-gateway-go/internal/grpcproxy"
+	"github.com/eshop/api-gateway-go/internal/maintenance"
+	"github.com/eshop/api-gateway-go/internal/middleware"
+	"github.com/eshop/api-gateway-go/internal/proxy"
+	"github.com/eshop/api-gateway-go/internal/usage"
+	"github.com/gin-gonic/gin"
+)
+
+// Deps are the gateway components the admin API inspects and controls
+type Deps struct {
+	Config      *config.Config
+	Routes      []*proxy.Route
+	Upstreams   []*proxy.Upstream
+	RateLimiter *middleware.IPRateLimiter
+	APIKeys     *apikey.Store
+	Maintenance *maintenance.Manager
+	GRPC        *grpcproxy.Proxy
+	Usage       *usage.Meter
+}
+
+// Server is the gateway's control-plane API. It listens on its own port so it
+// can be kept off the public load balancer.
+type Server struct {
+	router *gin.Engine
+	server *http.Server
+	deps   Deps
+
+	limitersMu sync.RWMutex
+	limiters   map[string]func()
+
+	cachesMu sync.RWMutex
+	caches   map[string]func(context.Context) error
+}
+
+// NewServer creates the admin server
+func NewServer(deps Deps) *Server {
+	router := gin.New()
+	router.Use(gin.Logger(), gin.Recovery())
+
+	s := &Server{
+		router:   router,
+		deps:     deps,
+		limiters: make(map[string]func()),
+		caches:   make(map[string]func(context.Context) error),
+	}
+
+	s.RegisterLimiter("rate-limiter", deps.RateLimiter.Purge)
+	s.RegisterLimiter("api-key-limiters", deps.APIKeys.PurgeLimiters)
+
+	api := router.Group("/", middleware.AdminAuth(deps.Config.AdminToken))
+
+	api.GET("/routes", s.listRoutes)
+
+	api.GET("/upstreams", s.listUpstreams)
+	api.POST("/upstreams/:name/drain", s.setDraining(true))
+	api.DELETE("/upstreams/:name/drain", s.setDraining(false))
+	api.POST("/upstreams/:name/circuit-breaker/reset", s.resetBreaker)
+
+	api.GET("/grpc", s.grpcStats)
+
+	api.GET("/rate-limits", s.rateLimits)
+	api.POST("/rate-limits/reset", s.resetRateLimits)
+	api.POST("/cache/purge", s.purgeCaches)
+	api.GET("/config", s.effectiveConfig)
+
+	registerAPIKeyRoutes(api, deps.APIKeys)
+	registerMaintenanceRoutes(api, deps.Maintenance, s.findRoute)
+	registerUsageRoutes(api, deps.Usage)
+
+	s.server = &http.Server{
+		Addr:    ":" + deps.Config.AdminPort,
+		Handler: router,
+	}
+
+	return s
+}
+
+// RegisterLimiter adds rate limiter buckets that POST /rate-limits/reset will clear
+func (s *Server) RegisterLimiter(name string, reset func()) {
+	s.limitersMu.Lock()
+	defer s.limitersMu.Unlock()
+
+	s.limiters[name] = reset
+}
+
+// RegisterCache adds a cache that POST /cache/purge will clear
+func (s *Server) RegisterCache(name string, purge func(context.Context) error) {
+	s.cachesMu.Lock()
+	defer s.cachesMu.Unlock()
+
+	s.caches[name] = purge
+}
+
+// Start starts the admin server
+func (s *Server) Start() error {
+	log.Printf("Starting gateway admin API on %s", s.server.Addr)
+	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to start admin server: %w", err)
+	}
+	return nil
+}
+
+// Shutdown gracefully shuts down the admin server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+type routeState struct {
+	proxy.RouteStats
+	Maintenance bool `json:"maintenance"`
+}
+
+func (s *Server) listRoutes(c *gin.Context) {
+	routes := make([]routeState, 0, len(s.deps.Routes))
+	for _, route := range s.deps.Routes {
+		routes = append(routes, routeState{
+			RouteStats:  route.Stats(),
+			Maintenance: s.deps.Maintenance.InMaintenance(route.Name),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"routes": routes})
+}
+
+type upstreamHealth struct {
+	proxy.UpstreamStats
+	Healthy     bool   `json:"healthy"`
+	HealthError string `json:"healthError,omitempty"`
+}
+
+func (s *Server) listUpstreams(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+
+	// Probe all upstreams concurrently so one dead service doesn't stall the listing
+	results := make([]upstreamHealth, len(s.deps.Upstreams))
+	var wg sync.WaitGroup
+	for i, upstream := range s.deps.Upstreams {
+		wg.Add(1)
+		go func(i int, upstream *proxy.Upstream) {
+			defer wg.Done()
+			result := upstreamHealth{UpstreamStats: upstream.Stats(), Healthy: true}
+			if err := upstream.CheckHealth(ctx); err != nil {
+				result.Healthy = false
+				result.HealthError = err.Error()
+			}
+			results[i] = result
+		}(i, upstream)
+	}
+	wg.Wait()
+
+	c.JSON(http.StatusOK, gin.H{"upstreams": results})
+}
+
+func (s *Server) setDraining(draining bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		upstream := s.findUpstream(c.Param("name"))
+		if upstream == nil {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Upstream not found"})
+			return
+		}
+
+		upstream.SetDraining(draining)
+		log.Printf("Admin: draining for upstream %s set to %v", upstream.Name, draining)
+		c.JSON(http.StatusOK, gin.H{"upstream": upstream.Stats()})
+	}
+}
+
+func (s *Server) resetBreaker(c *gin.Context) {
+	upstream := s.findUpstream(c.Param("name"))
+	if upstream == nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Upstream not found"})
+		return
+	}
+
+	upstream.ResetBreaker()
+	log.Printf("Admin: circuit breaker for upstream %s reset", upstream.Name)
+	c.JSON(http.StatusOK, gin.H{"upstream": upstream.Stats()})
+}
+
+func (s *Server) grpcStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"routes":   s.deps.GRPC.Routes(),
+		"statuses": s.deps.GRPC.Stats(),
+	})
+}
+
+func (s *Server) rateLimits(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"ip": s.deps.RateLimiter.Stats(),
+		"apiKeys": gin.H{
+			"activeLimiters": s.deps.APIKeys.ActiveLimiters(),
+		},
+	})
+}
+
+func (s *Server) resetRateLimits(c *gin.Context) {
+	s.limitersMu.RLock()
+	defer s.limitersMu.RUnlock()
+
+	reset := make([]string, 0, len(s.limiters))
+	for name, resetLimiter := range s.limiters {
+		resetLimiter()
+		reset = append(reset, name)
+	}
+	sort.Strings(reset)
+
+	log.Printf("Admin: reset rate limiters %v", reset)
+	c.JSON(http.StatusOK, gin.H{"reset": reset})
+}
+
+func (s *Server) purgeCaches(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	s.cachesMu.RLock()
+	defer s.cachesMu.RUnlock()
+
+	purged := make([]string, 0, len(s.caches))
+	failed := map[string]string{}
+	for name, purge := range s.caches {
+		if err := purge(ctx); err != nil {
+			failed[name] = err.Error()
+			continue
+		}
+		purged = append(purged, name)
+	}
+	sort.Strings(purged)
+
+	log.Printf("Admin: purged caches %v", purged)
+	if len(failed) > 0 {
+		log.Printf("Admin: failed to purge caches: %v", failed)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Some caches could not be purged", "purged": purged, "failed": failed})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
+func (s *Server) effectiveConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"config": s.deps.Config.Redacted()})
+}
+
+func (s *Server) findRoute(name string) *proxy.Route {
+	for _, route := range s.deps.Routes {
+		if route.Name == name {
+			return route
+		}
+	}
+	return nil
+}
+
+func (s *Server) findUpstream(name string) *proxy.Upstream {
+	for _, upstream := range s.deps.Upstreams {
+		if upstream.Name == name {
+			return upstream
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/eshop/api-gateway-go/internal/apikey"
+	"github.com/gin-gonic/gin"
+)
+
+// registerAPIKeyRoutes exposes create/list/revoke for machine-client API keys
+func registerAPIKeyRoutes(group *gin.RouterGroup, store *apikey.Store) {
+	group.GET("/api-keys", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"keys": store.List()})
+	})
+
+	group.POST("/api-keys", func(c *gin.Context) {
+		var req apikey.CreateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		plaintext, key, err := store.Create(req)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		key.Hash = ""
+		// The plaintext key is only ever shown once
+		c.JSON(http.StatusCreated, gin.H{
+			"key":    plaintext,
+			"apiKey": key,
+		})
+	})
+
+	group.DELETE("/api-keys/:id", func(c *gin.Context) {
+		if err := store.Revoke(c.Param("id")); err != nil {
+			if errors.Is(err, apikey.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+	})
+}
@@ -0,0 +1,108 @@
+package admin
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/eshop/api-gateway-go/internal/maintenance"
+	"github.com/eshop/api-gateway-go/internal/proxy"
+	"github.com/gin-gonic/gin"
+)
+
+// registerMaintenanceRoutes exposes runtime control of maintenance mode and downtime windows
+func registerMaintenanceRoutes(group *gin.RouterGroup, manager *maintenance.Manager, findRoute func(string) *proxy.Route) {
+	group.GET("/maintenance", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"maintenance": manager.State()})
+	})
+
+	group.PUT("/maintenance/global", func(c *gin.Context) {
+		var mode maintenance.Mode
+		if err := c.ShouldBindJSON(&mode); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		if err := manager.SetGlobal(mode); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		log.Printf("Admin: global maintenance set to %v", mode.Enabled)
+		c.JSON(http.StatusOK, gin.H{"maintenance": manager.State()})
+	})
+
+	group.PUT("/maintenance/routes/:name", func(c *gin.Context) {
+		route := findRoute(c.Param("name"))
+		if route == nil {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Route not found"})
+			return
+		}
+
+		var mode maintenance.Mode
+		if err := c.ShouldBindJSON(&mode); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		if err := manager.SetRoute(route.Name, mode); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		log.Printf("Admin: maintenance for route %s set to %v", route.Name, mode.Enabled)
+		c.JSON(http.StatusOK, gin.H{"maintenance": manager.State()})
+	})
+
+	group.DELETE("/maintenance/routes/:name", func(c *gin.Context) {
+		if err := manager.ClearRoute(c.Param("name")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"maintenance": manager.State()})
+	})
+
+	group.POST("/maintenance/windows", func(c *gin.Context) {
+		var window maintenance.Window
+		if err := c.ShouldBindJSON(&window); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		if window.Route != "" && findRoute(window.Route) == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Route not found"})
+			return
+		}
+
+		window, err := manager.AddWindow(window)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		log.Printf("Admin: scheduled maintenance window %s (%s - %s)", window.ID, window.Start, window.End)
+		c.JSON(http.StatusCreated, gin.H{"window": window})
+	})
+
+	group.DELETE("/maintenance/windows/:id", func(c *gin.Context) {
+		if err := manager.RemoveWindow(c.Param("id")); err != nil {
+			if errors.Is(err, maintenance.ErrWindowNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Maintenance window removed"})
+	})
+
+	group.PUT("/maintenance/bypass", func(c *gin.Context) {
+		var bypass maintenance.Bypass
+		if err := c.ShouldBindJSON(&bypass); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		if err := manager.SetBypass(bypass); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"maintenance": manager.State()})
+	})
+}
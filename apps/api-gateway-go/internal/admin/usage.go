@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/eshop/api-gateway-go/internal/usage"
+	"github.com/gin-gonic/gin"
+)
+
+// registerUsageRoutes exposes per-seller metering and plan assignment
+func registerUsageRoutes(group *gin.RouterGroup, meter *usage.Meter) {
+	group.GET("/usage", meter.ListHandler())
+	group.GET("/usage/:sellerId", meter.SellerHandler())
+
+	group.GET("/quotas", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"quotas": meter.Plans()})
+	})
+
+	group.PUT("/quotas/sellers/:sellerId", func(c *gin.Context) {
+		var req struct {
+			Tier string `json:"tier"` // Empty returns the seller to the default tier
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		sellerID := c.Param("sellerId")
+		if err := meter.SetSellerTier(sellerID, req.Tier); err != nil {
+			if errors.Is(err, usage.ErrUnknownTier) {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "Unknown tier"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		log.Printf("Admin: seller %s moved to tier %q", sellerID, req.Tier)
+		c.JSON(http.StatusOK, gin.H{"usage": meter.Summary(sellerID)})
+	})
+}
@@ -0,0 +1,304 @@
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eshop/api-gateway-go/internal/proxy"
+	"github.com/gin-gonic/gin"
+)
+
+// maxCallBody caps how much of each upstream response is buffered for merging
+const maxCallBody = 5 << 20
+
+// placeholderPattern matches {name} placeholders in call paths
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z0-9_.]+)\}`)
+
+// forwardedHeaders are copied from the client request onto every fan-out call
+var forwardedHeaders = []string{
+	"Cookie",
+	"Authorization",
+	"Accept-Language",
+	"X-User-Id",
+	"X-Seller-Id",
+	"X-Api-Key-Id",
+	"X-Bot-Score",
+	"X-Request-Id",
+}
+
+// Duration is a time.Duration that unmarshals from strings like "800ms"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Call is one upstream request made by a composite endpoint
+type Call struct {
+	Name     string   `json:"name"`     // Key of the result in the merged response
+	Upstream string   `json:"upstream"` // Upstream name, e.g. "product"
+	Method   string   `json:"method"`   // Defaults to GET
+	Path     string   `json:"path"`     // Upstream path; {param} and {query.name} are substituted
+	Timeout  Duration `json:"timeout"`
+	Required bool     `json:"required"` // The whole response fails if a required call fails
+}
+
+// Endpoint is a composite gateway endpoint that fans out to several upstreams
+type Endpoint struct {
+	Path    string   `json:"path"` // Gin route, e.g. /bff/products/:slug
+	Timeout Duration `json:"timeout"`
+	Calls   []Call   `json:"calls"`
+}
+
+// Policy applies the gateway's per-route policies to composite endpoints, for
+// the routes their calls go through
+type Policy struct {
+	// Middleware returns the handlers (bot scoring, metering, faults) mounted
+	// in front of an endpoint calling the given routes
+	Middleware func(routes []string) []gin.HandlerFunc
+	// Blocks reports whether calls to a route are refused for this request,
+	// e.g. during maintenance. Blocked calls fail without reaching the upstream.
+	Blocks func(c *gin.Context, route string) bool
+}
+
+// CallError describes why a call did not contribute to the response
+type CallError struct {
+	Status  int    `json:"status,omitempty"`
+	Message string `json:"message"`
+}
+
+// LoadEndpoints reads composite endpoint definitions from a JSON file
+func LoadEndpoints(path string) ([]Endpoint, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read aggregates file: %w", err)
+	}
+
+	var endpoints []Endpoint
+	if err := json.Unmarshal(data, &endpoints); err != nil {
+		return nil, fmt.Errorf("failed to parse aggregates file: %w", err)
+	}
+	return endpoints, nil
+}
+
+// Register mounts the composite endpoints on the router. Each call is matched
+// to the route that would serve its path, so the route's policies apply to it.
+func Register(router gin.IRoutes, endpoints []Endpoint, routes []*proxy.Route, policy Policy) error {
+	for _, endpoint := range endpoints {
+		if endpoint.Path == "" || len(endpoint.Calls) == 0 {
+			return fmt.Errorf("aggregate endpoint %q needs a path and at least one call", endpoint.Path)
+		}
+		seen := make(map[string]bool, len(endpoint.Calls))
+		callRoutes := make([]*proxy.Route, len(endpoint.Calls))
+		var names []string
+		for i, call := range endpoint.Calls {
+			if call.Name == "" || seen[call.Name] {
+				return fmt.Errorf("aggregate endpoint %s: call names must be unique and non-empty", endpoint.Path)
+			}
+			seen[call.Name] = true
+			route := routeFor(call, routes)
+			if route == nil {
+				return fmt.Errorf("aggregate endpoint %s: no route serves upstream %q", endpoint.Path, call.Upstream)
+			}
+			callRoutes[i] = route
+			if !contains(names, route.Name) {
+				names = append(names, route.Name)
+			}
+		}
+
+		var handlers []gin.HandlerFunc
+		if policy.Middleware != nil {
+			handlers = policy.Middleware(names)
+		}
+		handlers = append(handlers, handler(endpoint, callRoutes, policy.Blocks))
+		router.GET(endpoint.Path, handlers...)
+		log.Printf("Registered aggregate endpoint %s (%d calls via routes %v)", endpoint.Path, len(endpoint.Calls), names)
+	}
+	return nil
+}
+
+// routeFor finds the first route to the call's upstream that would serve its
+// path, the same way a client request would have been routed
+func routeFor(call Call, routes []*proxy.Route) *proxy.Route {
+	for _, route := range routes {
+		if route.Upstream == nil || route.Upstream.Name != call.Upstream {
+			continue
+		}
+		// Stripped prefixes never reach the upstream, so any path on it matches
+		if route.StripPrefix || route.Prefix == "" ||
+			call.Path == route.Prefix || strings.HasPrefix(call.Path, route.Prefix+"/") {
+			return route
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type callResult struct {
+	body json.RawMessage
+	err  *CallError
+}
+
+func handler(endpoint Endpoint, routes []*proxy.Route, blocks func(c *gin.Context, route string) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if endpoint.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(endpoint.Timeout))
+			defer cancel()
+		}
+
+		header := make(http.Header)
+		for _, name := range forwardedHeaders {
+			if value := c.Request.Header.Get(name); value != "" {
+				header.Set(name, value)
+			}
+		}
+		header.Set("Accept", "application/json")
+
+		// Expand paths up front, gin.Context isn't safe to read from several goroutines
+		paths := make([]string, len(endpoint.Calls))
+		for i, call := range endpoint.Calls {
+			paths[i] = expandPath(call.Path, c)
+		}
+
+		results := make([]callResult, len(endpoint.Calls))
+		var wg sync.WaitGroup
+		for i, call := range endpoint.Calls {
+			if blocks != nil && blocks(c, routes[i].Name) {
+				results[i] = callResult{err: &CallError{Status: http.StatusServiceUnavailable, Message: "service is under maintenance"}}
+				continue
+			}
+			wg.Add(1)
+			go func(i int, call Call) {
+				defer wg.Done()
+				results[i] = execute(ctx, call, routes[i].Upstream, paths[i], header)
+			}(i, call)
+		}
+		wg.Wait()
+
+		merged := make(map[string]interface{}, len(endpoint.Calls)+1)
+		errs := make(map[string]*CallError)
+		requiredFailed := false
+		for i, call := range endpoint.Calls {
+			if results[i].err != nil {
+				errs[call.Name] = results[i].err
+				merged[call.Name] = nil
+				if call.Required {
+					requiredFailed = true
+				}
+				continue
+			}
+			merged[call.Name] = results[i].body
+		}
+
+		if len(errs) > 0 {
+			merged["errors"] = errs
+			c.Header("X-Partial-Response", "true")
+		}
+		if requiredFailed {
+			c.JSON(http.StatusBadGateway, merged)
+			return
+		}
+		c.JSON(http.StatusOK, merged)
+	}
+}
+
+func execute(ctx context.Context, call Call, upstream *proxy.Upstream, path string, header http.Header) callResult {
+	if call.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(call.Timeout))
+		defer cancel()
+	}
+
+	method := call.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	resp, err := upstream.Fetch(ctx, method, path, header)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return callResult{err: &CallError{Status: http.StatusGatewayTimeout, Message: "timed out"}}
+		}
+		if errors.Is(err, proxy.ErrUnavailable) {
+			return callResult{err: &CallError{Status: http.StatusServiceUnavailable, Message: err.Error()}}
+		}
+		// Don't leak internal hostnames to the client
+		log.Printf("Aggregate call %s to %s failed: %v", call.Name, upstream.Name, err)
+		return callResult{err: &CallError{Status: http.StatusBadGateway, Message: "upstream request failed"}}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCallBody))
+	if err != nil {
+		log.Printf("Aggregate call %s to %s failed reading body: %v", call.Name, upstream.Name, err)
+		return callResult{err: &CallError{Status: http.StatusBadGateway, Message: "upstream request failed"}}
+	}
+	if resp.StatusCode >= 400 {
+		return callResult{err: &CallError{Status: resp.StatusCode, Message: upstreamMessage(body, resp.Status)}}
+	}
+	if !json.Valid(body) {
+		return callResult{err: &CallError{Status: http.StatusBadGateway, Message: "upstream returned invalid JSON"}}
+	}
+
+	return callResult{body: body}
+}
+
+// expandPath substitutes {param} with route params and {query.name} with query values
+func expandPath(path string, c *gin.Context) string {
+	return placeholderPattern.ReplaceAllStringFunc(path, func(match string) string {
+		name := match[1 : len(match)-1]
+		if strings.HasPrefix(name, "query.") {
+			return url.QueryEscape(c.Query(strings.TrimPrefix(name, "query.")))
+		}
+		return url.PathEscape(c.Param(name))
+	})
+}
+
+// upstreamMessage pulls the "message" field the Node services put in error bodies
+func upstreamMessage(body []byte, fallback string) string {
+	var parsed struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &parsed); err == nil && parsed.Message != "" {
+		return parsed.Message
+	}
+	return fallback
+}
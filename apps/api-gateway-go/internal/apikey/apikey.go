@@ -0,0 +1,288 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eshop/api-gateway-go/internal/fileutil"
+	"golang.org/x/time/rate"
+)
+
+// keyPrefix marks gateway-issued keys so they are easy to spot in logs and secret scanners
+const keyPrefix = "nmk"
+
+var (
+	ErrInvalidKey = errors.New("invalid API key")
+	ErrExpired    = errors.New("API key expired")
+	ErrRevoked    = errors.New("API key revoked")
+	ErrNotFound   = errors.New("API key not found")
+)
+
+// Key is a machine-client credential. Only the SHA-256 hash of the secret is stored.
+type Key struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash,omitempty"`
+	SellerID  string     `json:"sellerId"`
+	Scopes    []string   `json:"scopes"`    // Route prefixes the key may call, e.g. "/seller", "/products"
+	RateLimit float64    `json:"rateLimit"` // Requests per second
+	Burst     int        `json:"burst"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// AllowsPath reports whether the request path falls under one of the key's scopes
+func (k *Key) AllowsPath(path string) bool {
+	for _, scope := range k.Scopes {
+		scope = strings.TrimSuffix(scope, "/")
+		if path == scope || strings.HasPrefix(path, scope+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateRequest describes a new key
+type CreateRequest struct {
+	Name      string     `json:"name"`
+	SellerID  string     `json:"sellerId" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	RateLimit float64    `json:"rateLimit"`
+	Burst     int        `json:"burst"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// Store keeps API keys in memory and optionally persists them to a JSON file
+type Store struct {
+	keys     map[string]*Key
+	limiters map[string]*rate.Limiter
+	path     string
+	mu       sync.RWMutex
+
+	defaultRate  float64
+	defaultBurst int
+}
+
+// NewStore creates a key store. If path is non-empty, keys are loaded from and saved to that file.
+func NewStore(path string, defaultRate float64, defaultBurst int) (*Store, error) {
+	s := &Store{
+		keys:         make(map[string]*Key),
+		limiters:     make(map[string]*rate.Limiter),
+		path:         path,
+		defaultRate:  defaultRate,
+		defaultBurst: defaultBurst,
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read API keys file: %w", err)
+	}
+
+	var keys []*Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys file: %w", err)
+	}
+	for _, k := range keys {
+		s.keys[k.ID] = k
+	}
+
+	log.Printf("Loaded %d API keys from %s", len(keys), path)
+	return s, nil
+}
+
+// Create issues a new key. The plaintext key is only returned here and never stored.
+func (s *Store) Create(req CreateRequest) (string, *Key, error) {
+	if req.SellerID == "" {
+		return "", nil, errors.New("sellerId is required")
+	}
+	if len(req.Scopes) == 0 {
+		return "", nil, errors.New("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !strings.HasPrefix(scope, "/") {
+			return "", nil, fmt.Errorf("scope %q must be a route prefix starting with /", scope)
+		}
+	}
+
+	idBytes := make([]byte, 8)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", nil, err
+	}
+
+	id := hex.EncodeToString(idBytes)
+	plaintext := keyPrefix + "_" + id + "_" + base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	key := &Key{
+		ID:        id,
+		Name:      req.Name,
+		Hash:      hashKey(plaintext),
+		SellerID:  req.SellerID,
+		Scopes:    req.Scopes,
+		RateLimit: req.RateLimit,
+		Burst:     req.Burst,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: time.Now().UTC(),
+	}
+	if key.RateLimit <= 0 {
+		key.RateLimit = s.defaultRate
+	}
+	if key.Burst <= 0 {
+		key.Burst = s.defaultBurst
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[id] = key
+	if err := s.saveLocked(); err != nil {
+		delete(s.keys, id)
+		return "", nil, err
+	}
+
+	copied := *key
+	return plaintext, &copied, nil
+}
+
+// Revoke marks a key as revoked. Revoked keys are kept so they show up in listings.
+func (s *Store) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if key.RevokedAt == nil {
+		now := time.Now().UTC()
+		key.RevokedAt = &now
+	}
+	delete(s.limiters, id)
+
+	return s.saveLocked()
+}
+
+// List returns all keys sorted by creation time, without their hashes
+func (s *Store) List() []Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		copied := *k
+		copied.Hash = ""
+		keys = append(keys, copied)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// Authenticate resolves a plaintext key to its stored record
+func (s *Store) Authenticate(plaintext string) (*Key, error) {
+	parts := strings.SplitN(plaintext, "_", 3)
+	if len(parts) != 3 || parts[0] != keyPrefix {
+		return nil, ErrInvalidKey
+	}
+
+	s.mu.RLock()
+	stored, ok := s.keys[parts[1]]
+	var key Key
+	if ok {
+		key = *stored
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashKey(plaintext)), []byte(key.Hash)) != 1 {
+		return nil, ErrInvalidKey
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrRevoked
+	}
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		return nil, ErrExpired
+	}
+
+	return &key, nil
+}
+
+// Allow applies the key's own rate limit
+func (s *Store) Allow(key *Key) bool {
+	s.mu.Lock()
+	limiter, ok := s.limiters[key.ID]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(key.RateLimit), key.Burst)
+		s.limiters[key.ID] = limiter
+	}
+	s.mu.Unlock()
+
+	return limiter.Allow()
+}
+
+// ActiveLimiters returns the number of keys with a live rate limiter bucket
+func (s *Store) ActiveLimiters() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.limiters)
+}
+
+// PurgeLimiters drops all per-key rate limiter buckets
+func (s *Store) PurgeLimiters() {
+	s.mu.Lock()
+	s.limiters = make(map[string]*rate.Limiter)
+	s.mu.Unlock()
+}
+
+// saveLocked writes the keys file atomically. Caller must hold s.mu.
+func (s *Store) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+
+	keys := make([]*Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := fileutil.WriteAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to save API keys: %w", err)
+	}
+	return nil
+}
+
+func hashKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
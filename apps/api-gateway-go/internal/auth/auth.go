@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoToken      = errors.New("token missing")
+	ErrInvalidToken = errors.New("token invalid")
+	ErrExpiredToken = errors.New("token expired")
+)
+
+// Identity is the caller resolved from the access token issued by auth-service
+type Identity struct {
+	ID   string `json:"id"`
+	Role string `json:"role"` // user, seller or admin
+}
+
+// Identify reads the access token the same way isAuthenticated does in the Node
+// services (access_token cookie, seller_access_token cookie, then Bearer header)
+// and verifies it against ACCESS_TOKEN_SECRET.
+func Identify(r *http.Request, secret string) (*Identity, error) {
+	if secret == "" {
+		return nil, ErrNoToken
+	}
+
+	token := tokenFromRequest(r)
+	if token == "" {
+		return nil, ErrNoToken
+	}
+
+	return Verify(token, secret)
+}
+
+// Verify checks an HS256 JWT and returns its identity claims
+func Verify(token, secret string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims struct {
+		Identity
+		Exp int64 `json:"exp"`
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Exp != 0 && time.Now().Unix() >= claims.Exp {
+		return nil, ErrExpiredToken
+	}
+	if claims.ID == "" {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims.Identity, nil
+}
+
+func tokenFromRequest(r *http.Request) string {
+	if cookie, err := r.Cookie("access_token"); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	if cookie, err := r.Cookie("seller_access_token"); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return ""
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
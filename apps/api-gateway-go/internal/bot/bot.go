@@ -0,0 +1,157 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/eshop/api-gateway-go/internal/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// HeaderName carries the score of flagged requests to upstreams, from 0 (human)
+// to 100 (certainly automated). It is only ever set by the gateway.
+const HeaderName = "X-Bot-Score"
+
+// Modes, from least to most intrusive
+const (
+	ModeOff       = "off"       // No scoring
+	ModeTag       = "tag"       // Flagged requests get X-Bot-Score
+	ModeChallenge = "challenge" // Flagged requests must pass a JavaScript challenge first
+	ModeDeny      = "deny"      // Flagged requests are rejected
+)
+
+// Options configures a Detector
+type Options struct {
+	Mode          string
+	Routes        []string // Route names that are scored
+	Threshold     int      // Score at which a request is flagged
+	AllowedAgents []string // User-agent substrings that are tagged but never challenged or denied
+	Secret        string   // Signs challenge cookies
+}
+
+// Detector scores storefront requests for signs of automation
+type Detector struct {
+	mode      string
+	routes    map[string]bool
+	threshold int
+	allowed   []string
+	clients   *clientTracker
+	challenge *challenger
+}
+
+// New validates the options and creates a detector
+func New(opts Options) (*Detector, error) {
+	switch opts.Mode {
+	case ModeOff, ModeTag, ModeChallenge, ModeDeny:
+	default:
+		return nil, fmt.Errorf("unknown bot detection mode %q", opts.Mode)
+	}
+	if opts.Threshold < 1 || opts.Threshold > 100 {
+		return nil, fmt.Errorf("bot score threshold must be between 1 and 100, got %d", opts.Threshold)
+	}
+
+	d := &Detector{
+		mode:      opts.Mode,
+		routes:    make(map[string]bool, len(opts.Routes)),
+		threshold: opts.Threshold,
+		clients:   newClientTracker(),
+		challenge: newChallenger(opts.Secret),
+	}
+	for _, route := range opts.Routes {
+		d.routes[route] = true
+	}
+	for _, agent := range opts.AllowedAgents {
+		d.allowed = append(d.allowed, strings.ToLower(agent))
+	}
+	if d.mode != ModeOff {
+		log.Printf("Bot detection in %s mode on routes %v (threshold %d)", d.mode, opts.Routes, d.threshold)
+	}
+	return d, nil
+}
+
+// RegisterChallenge serves the challenge page that non-browser requests are
+// pointed at, and verifies its solutions
+func (d *Detector) RegisterChallenge(router gin.IRoutes) {
+	if d.mode == ModeChallenge {
+		router.GET(challengePath, d.challenge.Handler())
+		router.POST(challengePath, d.challenge.VerifyHandler())
+	}
+}
+
+// StripHeader drops any client-supplied X-Bot-Score. It runs globally, ahead
+// of handlers such as the gRPC proxy that never reach a route's Middleware,
+// since upstreams trust the header once the gateway signs it.
+func StripHeader() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Header.Del(HeaderName)
+		c.Next()
+	}
+}
+
+// Middleware scores requests on the routes and applies the configured mode.
+// Requests are scored when any of the routes is; composite endpoints pass every
+// route they call.
+func (d *Detector) Middleware(routes ...string) gin.HandlerFunc {
+	scored := false
+	for _, route := range routes {
+		scored = scored || d.routes[route]
+	}
+
+	return func(c *gin.Context) {
+		if d.mode == ModeOff || !scored {
+			c.Next()
+			return
+		}
+		// API key clients are known machine clients
+		if _, ok := c.Get(middleware.APIKeyContextKey); ok {
+			c.Next()
+			return
+		}
+
+		score, reasons := d.Score(c.Request, c.ClientIP())
+		if score < d.threshold {
+			c.Next()
+			return
+		}
+
+		c.Request.Header.Set(HeaderName, strconv.Itoa(score))
+		if d.allowedAgent(c.Request.UserAgent()) {
+			c.Next()
+			return
+		}
+
+		switch d.mode {
+		case ModeDeny:
+			log.Printf("Bot: denied %s %s from %s (score %d: %s)", c.Request.Method, c.Request.URL.Path, c.ClientIP(), score, strings.Join(reasons, ", "))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Automated traffic is not allowed"})
+			return
+		case ModeChallenge:
+			if d.challenge.Passed(c.Request, c.ClientIP()) {
+				break
+			}
+			log.Printf("Bot: challenged %s %s from %s (score %d: %s)", c.Request.Method, c.Request.URL.Path, c.ClientIP(), score, strings.Join(reasons, ", "))
+			d.challenge.Issue(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Purge forgets every client's request history, e.g. after a load test skewed
+// the rate signals. Solved challenges are kept so they still can't be replayed.
+func (d *Detector) Purge() {
+	d.clients.purge()
+}
+
+func (d *Detector) allowedAgent(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	for _, agent := range d.allowed {
+		if strings.Contains(userAgent, agent) {
+			return true
+		}
+	}
+	return false
+}
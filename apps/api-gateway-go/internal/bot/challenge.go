@@ -0,0 +1,235 @@
+package bot
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"log"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	challengePath  = "/gateway/bot-challenge"
+	cookieName     = "gw_bot_check"
+	challengeValid = time.Hour
+
+	// A solution needs about 2^16 SHA-256 hashes, around a second in a browser
+	challengeDifficulty = 16
+	challengeTTL        = 5 * time.Minute
+)
+
+// The page has to find a nonce whose hash with the challenge starts with
+// challengeDifficulty zero bits and POST it back; only then is the cookie set.
+// crypto.subtle needs a secure context (HTTPS or localhost).
+var challengePage = template.Must(template.New("challenge").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Checking your browser</title></head>
+<body>
+<p id="status">Checking your browser&hellip;</p>
+<noscript><p>Please enable JavaScript to continue.</p></noscript>
+<script>
+(async function () {
+  var challenge = {{.Challenge}}, difficulty = {{.Difficulty}}, encoder = new TextEncoder();
+  var nonce = 0;
+  for (;; nonce++) {
+    var hash = new Uint8Array(await crypto.subtle.digest("SHA-256", encoder.encode(challenge + ":" + nonce)));
+    if (leadingZeros(hash) >= difficulty) break;
+  }
+  var response = await fetch({{.Path}}, {
+    method: "POST",
+    credentials: "same-origin",
+    headers: {"Content-Type": "application/x-www-form-urlencoded"},
+    body: "challenge=" + encodeURIComponent(challenge) + "&nonce=" + nonce
+  });
+  if (!response.ok) {
+    document.getElementById("status").textContent = "Verification failed, please reload the page.";
+    return;
+  }
+  {{if .Redirect}}location.replace({{.Redirect}});{{else}}location.reload();{{end}}
+})();
+
+function leadingZeros(hash) {
+  var count = 0;
+  for (var i = 0; i < hash.length; i++) {
+    if (hash[i] !== 0) return count + Math.clz32(hash[i]) - 24;
+    count += 8;
+  }
+  return count;
+}
+</script>
+</body>
+</html>
+`))
+
+// challenger issues proof-of-work challenges and checks the signed cookies
+// given for solving them, both bound to the client's IP and user-agent
+type challenger struct {
+	secret []byte
+
+	mu     sync.Mutex
+	solved map[string]time.Time // Challenge -> when it expires, so each is used once
+}
+
+func newChallenger(secret string) *challenger {
+	key := []byte(secret)
+	if len(key) == 0 {
+		// Cookies then only survive until a restart and aren't shared between instances
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Printf("Bot: failed to generate challenge secret: %v", err)
+		}
+	}
+	return &challenger{secret: key, solved: make(map[string]time.Time)}
+}
+
+// Passed reports whether the request carries a valid, unexpired challenge cookie
+func (ch *challenger) Passed(r *http.Request, clientIP string) bool {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return false
+	}
+	expiresRaw, mac, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiresRaw, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(ch.sign("cookie", expiresRaw, clientIP, r.UserAgent())))
+}
+
+// Issue answers a flagged request with the challenge. Pages get it inline and
+// reload once it is solved; API calls are told where to find it.
+func (ch *challenger) Issue(c *gin.Context) {
+	if strings.Contains(c.GetHeader("Accept"), "text/html") {
+		ch.render(c, http.StatusForbidden, "")
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"message":   "Bot challenge required",
+		"challenge": challengePath + "?redirect=" + url.QueryEscape(c.Request.URL.RequestURI()),
+	})
+}
+
+// Handler serves the challenge page, going to the redirect parameter afterwards
+func (ch *challenger) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		redirect := c.Query("redirect")
+		// Only same-site paths, never another host
+		if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+			redirect = "/"
+		}
+		ch.render(c, http.StatusOK, redirect)
+	}
+}
+
+// VerifyHandler checks a solved challenge and sets the cookie
+func (ch *challenger) VerifyHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		challenge, nonce := c.PostForm("challenge"), c.PostForm("nonce")
+		if !ch.verify(challenge, nonce, c.ClientIP(), c.Request.UserAgent()) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Bot challenge failed"})
+			return
+		}
+
+		expires := time.Now().Add(challengeValid).Unix()
+		expiresRaw := strconv.FormatInt(expires, 10)
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     cookieName,
+			Value:    expiresRaw + "." + ch.sign("cookie", expiresRaw, c.ClientIP(), c.Request.UserAgent()),
+			Path:     "/",
+			MaxAge:   int(challengeValid.Seconds()),
+			HttpOnly: true,
+			Secure:   c.Request.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func (ch *challenger) render(c *gin.Context, status int, redirect string) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		log.Printf("Bot: failed to generate challenge: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+	issued := strconv.FormatInt(time.Now().Unix(), 10)
+	salt := hex.EncodeToString(random)
+	challenge := issued + "." + salt + "." + ch.sign("challenge", issued, salt, c.ClientIP(), c.Request.UserAgent())
+
+	c.Abort()
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	err := challengePage.Execute(c.Writer, map[string]any{
+		"Challenge":  challenge,
+		"Difficulty": challengeDifficulty,
+		"Path":       challengePath,
+		"Redirect":   redirect,
+	})
+	if err != nil {
+		log.Printf("Bot: failed to render challenge: %v", err)
+	}
+}
+
+// verify checks that challenge was issued to this client recently, hasn't been
+// used yet, and that nonce solves it
+func (ch *challenger) verify(challenge, nonce, clientIP, userAgent string) bool {
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 3 || len(nonce) == 0 || len(nonce) > 20 {
+		return false
+	}
+	issued, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Since(time.Unix(issued, 0)) > challengeTTL {
+		return false
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(ch.sign("challenge", parts[0], parts[1], clientIP, userAgent))) {
+		return false
+	}
+	if leadingZeros(sha256.Sum256([]byte(challenge+":"+nonce))) < challengeDifficulty {
+		return false
+	}
+
+	now := time.Now()
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	for used, expires := range ch.solved {
+		if now.After(expires) {
+			delete(ch.solved, used)
+		}
+	}
+	if _, used := ch.solved[challenge]; used {
+		return false
+	}
+	ch.solved[challenge] = time.Unix(issued, 0).Add(challengeTTL)
+	return true
+}
+
+func (ch *challenger) sign(values ...string) string {
+	mac := hmac.New(sha256.New, ch.secret)
+	mac.Write([]byte(strings.Join(values, "|")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func leadingZeros(hash [sha256.Size]byte) int {
+	count := 0
+	for _, b := range hash {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
@@ -0,0 +1,185 @@
+package bot
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Libraries and automation tools that announce themselves
+var toolAgents = []string{
+	"curl", "wget", "python-requests", "python-urllib", "aiohttp", "httpx", "go-http-client",
+	"java/", "okhttp", "apache-httpclient", "libwww-perl", "node-fetch", "axios", "scrapy",
+	"headlesschrome", "phantomjs", "selenium", "puppeteer", "playwright",
+}
+
+// Words crawlers put in their user-agent
+var crawlerAgents = []string{"bot", "crawler", "spider", "slurp"}
+
+// Score rates how likely a request is automated, 0-100, along with the signals
+// that contributed
+func (d *Detector) Score(r *http.Request, clientIP string) (int, []string) {
+	score := 0
+	var reasons []string
+	add := func(points int, reason string) {
+		score += points
+		reasons = append(reasons, reason)
+	}
+
+	// User-agent heuristics
+	userAgent := strings.ToLower(r.UserAgent())
+	switch {
+	case userAgent == "":
+		add(40, "no user-agent")
+	case containsAny(userAgent, toolAgents):
+		add(45, "automation user-agent")
+	case containsAny(userAgent, crawlerAgents):
+		add(35, "crawler user-agent")
+	case !strings.HasPrefix(userAgent, "mozilla/"):
+		add(10, "non-browser user-agent")
+	}
+
+	// Header fingerprint: browsers always send these
+	if r.Header.Get("Accept") == "" {
+		add(10, "no accept")
+	}
+	if r.Header.Get("Accept-Language") == "" {
+		add(15, "no accept-language")
+	}
+	if r.Header.Get("Accept-Encoding") == "" {
+		add(10, "no accept-encoding")
+	}
+	// A browser user-agent without the headers that browser sends is spoofed
+	if strings.HasPrefix(userAgent, "mozilla/") {
+		if r.Header.Get("Sec-Fetch-Mode") == "" {
+			add(15, "browser user-agent without sec-fetch headers")
+		}
+		if strings.Contains(userAgent, "chrome/") && r.Header.Get("Sec-Ch-Ua") == "" {
+			add(10, "chrome user-agent without client hints")
+		}
+	}
+
+	// Scrapers rarely keep a cookie jar
+	if r.Header.Get("Cookie") == "" {
+		add(15, "no cookies")
+	}
+
+	// Request rate patterns
+	burst, regular := d.clients.record(clientIP, time.Now())
+	if burst > 0 {
+		add(burst, "request burst")
+	}
+	if regular {
+		add(20, "machine-regular request timing")
+	}
+
+	if score > 100 {
+		score = 100
+	}
+	return score, reasons
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	historySize  = 20               // Recent request times kept per client
+	burstWindow  = 10 * time.Second // Window for counting bursts
+	burstAllowed = 10               // Requests per window before it counts as a burst
+	clientIdle   = 10 * time.Minute // Clients unseen this long are forgotten
+)
+
+// clientTracker remembers each client's recent request times
+type clientTracker struct {
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastPrune time.Time
+}
+
+type client struct {
+	times []time.Time // Oldest first, at most historySize
+}
+
+func newClientTracker() *clientTracker {
+	return &clientTracker{clients: make(map[string]*client), lastPrune: time.Now()}
+}
+
+// record notes a request and returns burst points (0-25) and whether the
+// client's timing is too regular to be a person
+func (t *clientTracker) record(ip string, now time.Time) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.lastPrune) > time.Minute {
+		for key, c := range t.clients {
+			if now.Sub(c.times[len(c.times)-1]) > clientIdle {
+				delete(t.clients, key)
+			}
+		}
+		t.lastPrune = now
+	}
+
+	c, ok := t.clients[ip]
+	if !ok {
+		c = &client{}
+		t.clients[ip] = c
+	}
+	c.times = append(c.times, now)
+	if len(c.times) > historySize {
+		c.times = c.times[1:]
+	}
+
+	recent := 0
+	for _, at := range c.times {
+		if now.Sub(at) <= burstWindow {
+			recent++
+		}
+	}
+	burst := 0
+	if recent > burstAllowed {
+		burst = int(math.Min(25, float64(recent-burstAllowed)*2.5))
+	}
+
+	return burst, regularTiming(c.times)
+}
+
+func (t *clientTracker) purge() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.clients = make(map[string]*client)
+}
+
+// regularTiming reports whether the gaps between requests barely vary, as with
+// a script on a fixed interval. People click at irregular times.
+func regularTiming(times []time.Time) bool {
+	if len(times) < 11 {
+		return false
+	}
+
+	gaps := make([]float64, len(times)-1)
+	var mean float64
+	for i := 1; i < len(times); i++ {
+		gaps[i-1] = float64(times[i].Sub(times[i-1]))
+		mean += gaps[i-1]
+	}
+	mean /= float64(len(gaps))
+	if mean <= 0 {
+		return false
+	}
+
+	var variance float64
+	for _, gap := range gaps {
+		variance += (gap - mean) * (gap - mean)
+	}
+	variance /= float64(len(gaps))
+	return math.Sqrt(variance)/mean < 0.15
+}
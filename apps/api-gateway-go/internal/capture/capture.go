@@ -0,0 +1,344 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/eshop/api-gateway-go/internal/middleware"
+	"github.com/eshop/api-gateway-go/internal/proxy"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxBody caps how much of each body is captured
+	maxBody = 256 << 10
+	// queueSize is how many entries may wait for the writer before new ones are dropped
+	queueSize = 1024
+
+	redacted = "[REDACTED]"
+)
+
+// sensitiveHeaders are never written to disk
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+}
+
+// Entry is one captured request/response pair, written as a line of JSON
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Route    string    `json:"route"`
+	Upstream string    `json:"upstream"`
+	Request  Request   `json:"request"`
+	Response Response  `json:"response"`
+}
+
+// Request is the captured request as the upstream received it
+type Request struct {
+	Method   string            `json:"method"`
+	Path     string            `json:"path"` // After the route prefix is stripped
+	Query    string            `json:"query,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Body     json.RawMessage   `json:"body,omitempty"`     // JSON bodies
+	BodyText string            `json:"bodyText,omitempty"` // Other text bodies
+}
+
+// Response is the captured upstream response
+type Response struct {
+	Status     int               `json:"status"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       json.RawMessage   `json:"body,omitempty"`
+	BodyText   string            `json:"bodyText,omitempty"`
+	DurationMs int64             `json:"durationMs"`
+}
+
+// Recorder samples traffic on chosen routes into one JSONL file per route and day
+type Recorder struct {
+	dir          string
+	routes       map[string]bool
+	sampleRate   float64
+	redactFields map[string]bool // Lower-cased JSON and form field names
+
+	mu      sync.RWMutex // Guards sending on entries against Close
+	closed  bool
+	entries chan *Entry
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// New creates a recorder writing to dir. Capture is off unless both dir and
+// routes are set.
+func New(dir string, routes []string, sampleRate float64, redactFields []string) (*Recorder, error) {
+	r := &Recorder{
+		dir:          dir,
+		routes:       make(map[string]bool),
+		sampleRate:   sampleRate,
+		redactFields: make(map[string]bool),
+	}
+	if dir == "" || len(routes) == 0 {
+		return r, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+	for _, route := range routes {
+		r.routes[route] = true
+	}
+	for _, field := range redactFields {
+		r.redactFields[strings.ToLower(field)] = true
+	}
+
+	r.entries = make(chan *Entry, queueSize)
+	r.done = make(chan struct{})
+	go r.run()
+
+	log.Printf("Capturing %.0f%% of traffic on routes %v to %s", sampleRate*100, routes, dir)
+	return r, nil
+}
+
+// Middleware captures a sample of the route's traffic
+func (r *Recorder) Middleware(route *proxy.Route) gin.HandlerFunc {
+	if !r.routes[route.Name] {
+		return func(c *gin.Context) { c.Next() }
+	}
+	stripPrefix := ""
+	if route.StripPrefix {
+		stripPrefix = route.Prefix
+	}
+
+	return func(c *gin.Context) {
+		// WebSockets aren't request/response pairs
+		if c.GetHeader("Upgrade") != "" || rand.Float64() >= r.sampleRate {
+			c.Next()
+			return
+		}
+
+		requestBody, complete := peekBody(c.Request)
+		path := strings.TrimPrefix(c.Request.URL.Path, stripPrefix)
+		if path == "" {
+			path = "/"
+		}
+
+		entry := &Entry{
+			Time:     time.Now().UTC(),
+			Route:    route.Name,
+			Upstream: route.Upstream.Name,
+			Request: Request{
+				Method:  c.Request.Method,
+				Path:    path,
+				Query:   r.redactQuery(c.Request.URL.RawQuery),
+				Headers: headers(c.Request.Header),
+			},
+		}
+		if complete {
+			entry.Request.Body, entry.Request.BodyText = r.body(c.Request.Header, requestBody)
+		}
+
+		recorder := middleware.NewResponseRecorder(c.Writer, maxBody)
+		c.Writer = recorder
+		c.Next()
+		c.Writer = recorder.ResponseWriter
+
+		entry.Response = Response{
+			Status:     recorder.Status(),
+			Headers:    headers(recorder.Header()),
+			DurationMs: time.Since(entry.Time).Milliseconds(),
+		}
+		if !recorder.Truncated() {
+			entry.Response.Body, entry.Response.BodyText = r.body(recorder.Header(), recorder.Body())
+		}
+
+		r.enqueue(entry)
+	}
+}
+
+func (r *Recorder) enqueue(entry *Entry) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return
+	}
+	select {
+	case r.entries <- entry:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// Close flushes queued entries and closes the capture files
+func (r *Recorder) Close() {
+	if r.entries == nil {
+		return
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.entries)
+	r.mu.Unlock()
+	<-r.done
+
+	if dropped := r.dropped.Load(); dropped > 0 {
+		log.Printf("Capture: dropped %d entries because the writer fell behind", dropped)
+	}
+}
+
+// run writes entries in the background so requests never wait on the disk
+func (r *Recorder) run() {
+	defer close(r.done)
+
+	files := make(map[string]*os.File) // Route -> current file
+	names := make(map[string]string)   // Route -> current file name
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	for entry := range r.entries {
+		name := filepath.Join(r.dir, fmt.Sprintf("%s-%s.jsonl", entry.Route, entry.Time.Format("2006-01-02")))
+		if names[entry.Route] != name {
+			if file := files[entry.Route]; file != nil {
+				file.Close()
+			}
+			// Captures can contain personal data, keep them private to the gateway user
+			file, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+			if err != nil {
+				log.Printf("Capture: failed to open %s: %v", name, err)
+				delete(files, entry.Route)
+				delete(names, entry.Route)
+				continue
+			}
+			files[entry.Route] = file
+			names[entry.Route] = name
+		}
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("Capture: failed to encode entry: %v", err)
+			continue
+		}
+		if _, err := files[entry.Route].Write(append(line, '\n')); err != nil {
+			log.Printf("Capture: failed to write %s: %v", name, err)
+		}
+	}
+}
+
+// peekBody reads up to maxBody of the request body and puts it back for the proxy.
+// complete is false when the body was larger than that.
+func peekBody(req *http.Request) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+
+	data, err := io.ReadAll(io.LimitReader(req.Body, maxBody+1))
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), req.Body), req.Body}
+
+	if err != nil || len(data) > maxBody {
+		return nil, false
+	}
+	return data, true
+}
+
+// body returns a redacted JSON body, or the text of other textual bodies.
+// Compressed and binary bodies are left out.
+func (r *Recorder) body(header http.Header, data []byte) (json.RawMessage, string) {
+	if len(data) == 0 {
+		return nil, ""
+	}
+	if encoding := header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return nil, ""
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		return nil, r.redactQuery(string(data))
+	case json.Valid(data):
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, ""
+		}
+		redactedJSON, err := json.Marshal(r.redactJSON(value))
+		if err != nil {
+			return nil, ""
+		}
+		return redactedJSON, ""
+	case utf8.Valid(data):
+		return nil, string(data)
+	}
+	return nil, ""
+}
+
+// redactJSON replaces configured fields at any depth
+func (r *Recorder) redactJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if r.redactFields[strings.ToLower(key)] {
+				v[key] = redacted
+				continue
+			}
+			v[key] = r.redactJSON(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = r.redactJSON(item)
+		}
+	}
+	return value
+}
+
+// redactQuery replaces configured fields in a query string or form body
+func (r *Recorder) redactQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return ""
+	}
+	for key := range values {
+		if r.redactFields[strings.ToLower(key)] {
+			values[key] = []string{redacted}
+		}
+	}
+	return values.Encode()
+}
+
+func headers(header http.Header) map[string]string {
+	captured := make(map[string]string, len(header))
+	for name, values := range header {
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			captured[name] = redacted
+			continue
+		}
+		captured[name] = strings.Join(values, ", ")
+	}
+	return captured
+}
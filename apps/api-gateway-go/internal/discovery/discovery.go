@@ -0,0 +1,247 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider resolves the current set of targets for an upstream
+type Provider interface {
+	Resolve(ctx context.Context) ([]*url.URL, error)
+	String() string
+}
+
+// ForURL picks a provider from a service URL:
+//
+//	http://product-service:6002               static
+//	dns://product-service:6002                A/AAAA records, http on the given port
+//	dns+https://product-service:6002          the same over https
+//	srv://_http._tcp.product-service          SRV records, hosts and ports from DNS
+//	srv+https://_https._tcp.product-service   the same over https
+func ForURL(raw string) (Provider, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse service URL %s: %w", raw, err)
+	}
+
+	kind, scheme, _ := strings.Cut(parsed.Scheme, "+")
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	switch kind {
+	case "dns":
+		if parsed.Port() == "" {
+			return nil, fmt.Errorf("DNS service URL %s needs a port", raw)
+		}
+		return &DNS{Scheme: scheme, Host: parsed.Hostname(), Port: parsed.Port(), Path: parsed.Path}, nil
+	case "srv":
+		return &SRV{Scheme: scheme, Name: parsed.Host, Path: parsed.Path}, nil
+	}
+	return &Static{URLs: []*url.URL{parsed}}, nil
+}
+
+// Static always returns the same targets
+type Static struct {
+	URLs []*url.URL
+}
+
+func (s *Static) Resolve(context.Context) ([]*url.URL, error) {
+	return s.URLs, nil
+}
+
+func (s *Static) String() string {
+	return "static"
+}
+
+// DNS resolves a hostname's A/AAAA records, e.g. a headless Kubernetes service
+// or a scaled compose service
+type DNS struct {
+	Scheme string
+	Host   string
+	Port   string
+	Path   string
+}
+
+func (d *DNS) Resolve(ctx context.Context) ([]*url.URL, error) {
+	addrs, err := net.DefaultResolver.LookupHost(ctx, d.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]*url.URL, 0, len(addrs))
+	for _, addr := range addrs {
+		targets = append(targets, &url.URL{Scheme: d.Scheme, Host: net.JoinHostPort(addr, d.Port), Path: d.Path})
+	}
+	return targets, nil
+}
+
+func (d *DNS) String() string {
+	return "dns " + d.Host
+}
+
+// SRV resolves SRV records. Only the records with the best (lowest) priority are used.
+type SRV struct {
+	Scheme string
+	Name   string // Full record name, e.g. _http._tcp.product-service.default.svc.cluster.local
+	Path   string
+}
+
+func (s *SRV) Resolve(ctx context.Context) ([]*url.URL, error) {
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", s.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []*url.URL
+	for _, record := range records {
+		if record.Priority != records[0].Priority {
+			break
+		}
+		host := strings.TrimSuffix(record.Target, ".")
+		targets = append(targets, &url.URL{
+			Scheme: s.Scheme,
+			Host:   net.JoinHostPort(host, strconv.Itoa(int(record.Port))),
+			Path:   s.Path,
+		})
+	}
+	return targets, nil
+}
+
+func (s *SRV) String() string {
+	return "srv " + s.Name
+}
+
+// File reads an upstream's targets from a JSON registry mapping upstream names
+// to URL lists. It is re-read on every refresh, so edits are picked up live.
+type File struct {
+	Path     string
+	Upstream string
+}
+
+// Registered reports whether the registry file lists the upstream
+func Registered(path, upstream string) bool {
+	registry, err := readRegistry(path)
+	if err != nil {
+		return false
+	}
+	_, ok := registry[upstream]
+	return ok
+}
+
+func (f *File) Resolve(context.Context) ([]*url.URL, error) {
+	registry, err := readRegistry(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := registry[f.Upstream]
+	if !ok {
+		return nil, fmt.Errorf("upstream %s is not in %s", f.Upstream, f.Path)
+	}
+	targets := make([]*url.URL, 0, len(raw))
+	for _, target := range raw {
+		parsed, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %q for %s: %w", target, f.Upstream, err)
+		}
+		targets = append(targets, parsed)
+	}
+	return targets, nil
+}
+
+func (f *File) String() string {
+	return "file " + f.Path
+}
+
+func readRegistry(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read discovery registry: %w", err)
+	}
+	var registry map[string][]string
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return nil, fmt.Errorf("failed to parse discovery registry: %w", err)
+	}
+	return registry, nil
+}
+
+// Watcher keeps an upstream's targets in sync with its provider
+type Watcher struct {
+	Upstream string
+	Provider Provider
+	Interval time.Duration
+	Apply    func([]*url.URL)
+
+	mu      sync.Mutex // Refresh runs from Run and from the admin API
+	current string
+}
+
+// Refresh resolves once and applies the result if it changed. An error or an
+// empty result keeps the previous targets.
+func (w *Watcher) Refresh(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	targets, err := w.Provider.Resolve(ctx)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("%s returned no targets", w.Provider)
+	}
+
+	// Resolvers don't promise an order, compare sorted
+	sort.Slice(targets, func(i, j int) bool { return targets[i].String() < targets[j].String() })
+	names := make([]string, len(targets))
+	for i, target := range targets {
+		names[i] = target.Redacted()
+	}
+	key := strings.Join(names, ",")
+	if key == w.current {
+		return nil
+	}
+
+	w.Apply(targets)
+	w.current = key
+	log.Printf("Discovery: upstream %s now has %d targets %v (%s)", w.Upstream, len(targets), names, w.Provider)
+	return nil
+}
+
+// Run refreshes on every interval until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	lastErr := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := w.Refresh(ctx)
+			if err == nil {
+				lastErr = ""
+				continue
+			}
+			// Log once per distinct failure rather than on every tick
+			if err.Error() != lastErr {
+				log.Printf("Discovery: keeping previous targets for %s: %v", w.Upstream, err)
+				lastErr = err.Error()
+			}
+		}
+	}
+}
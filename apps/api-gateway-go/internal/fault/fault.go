@@ -0,0 +1,282 @@
+package fault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// HeaderName forces faults on a single request, e.g.
+// "latency=800ms; status=503" or "abort" or "bandwidth=20480". It is ignored
+// unless header faults are explicitly enabled.
+const HeaderName = "X-Gateway-Fault"
+
+// Bounds on faults clients may request, so the header can't be used to hold
+// connections open
+const (
+	maxHeaderLatency   = 30 * time.Second
+	minHeaderBandwidth = 16 << 10         // Bytes per second
+	maxHeaderThrottle  = 30 * time.Second // Throttling stops after this
+)
+
+// Latency adds delay drawn from a distribution
+type Latency struct {
+	Distribution string  `json:"distribution"` // fixed (default), uniform, normal or exponential
+	Ms           int     `json:"ms"`           // fixed
+	MinMs        int     `json:"minMs"`        // uniform
+	MaxMs        int     `json:"maxMs"`        // uniform
+	MeanMs       int     `json:"meanMs"`       // normal, exponential
+	StddevMs     int     `json:"stddevMs"`     // normal
+	Percent      float64 `json:"percent"`      // Share of requests delayed, 0-100
+}
+
+// Rule is the set of faults injected on a route
+type Rule struct {
+	Latency              *Latency `json:"latency"`
+	ErrorStatus          int      `json:"errorStatus"`
+	ErrorPercent         float64  `json:"errorPercent"`
+	AbortPercent         float64  `json:"abortPercent"`         // Connection closed without a response
+	BandwidthBytesPerSec int      `json:"bandwidthBytesPerSec"` // Response bodies are throttled to this
+}
+
+// Injector applies faults to routes. It does nothing in production.
+type Injector struct {
+	enabled bool
+	header  bool            // Whether X-Gateway-Fault is honoured
+	rules   map[string]Rule // Route name -> rule
+}
+
+// Load reads rules keyed by route name from a JSON file. production disables
+// injection entirely; the header is only honoured when allowHeader is set.
+func Load(path string, production, allowHeader bool) (*Injector, error) {
+	i := &Injector{enabled: !production, header: !production && allowHeader, rules: make(map[string]Rule)}
+	if i.header {
+		log.Printf("Fault injection through the %s header is enabled", HeaderName)
+	}
+	if path == "" {
+		return i, nil
+	}
+	if production {
+		log.Printf("Fault injection is disabled in production, ignoring %s", path)
+		return i, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read faults file: %w", err)
+	}
+	if err := json.Unmarshal(data, &i.rules); err != nil {
+		return nil, fmt.Errorf("failed to parse faults file: %w", err)
+	}
+	for route, rule := range i.rules {
+		if rule.ErrorPercent > 0 && (rule.ErrorStatus < 400 || rule.ErrorStatus > 599) {
+			return nil, fmt.Errorf("fault rule for %s needs an errorStatus between 400 and 599", route)
+		}
+		log.Printf("Fault injection enabled on route %s", route)
+	}
+	return i, nil
+}
+
+// Middleware injects the route's configured faults, or those requested by
+// the X-Gateway-Fault header when header faults are enabled
+func (i *Injector) Middleware(route string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader(HeaderName)
+		// Never let the header reach upstreams
+		c.Request.Header.Del(HeaderName)
+
+		if !i.enabled {
+			c.Next()
+			return
+		}
+
+		rule, configured := i.rules[route]
+		forced := header != "" && i.header
+		if forced {
+			requested, err := parseHeader(header)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+				return
+			}
+			rule, configured = requested, true
+		}
+		if !configured {
+			c.Next()
+			return
+		}
+
+		if rule.Latency != nil && chance(rule.Latency.Percent) {
+			if !sleep(c.Request.Context(), rule.Latency.sample()) {
+				c.Abort()
+				return
+			}
+		}
+
+		if chance(rule.AbortPercent) {
+			log.Printf("Fault: aborting %s %s on route %s", c.Request.Method, c.Request.URL.Path, route)
+			abort(c)
+			return
+		}
+
+		if chance(rule.ErrorPercent) {
+			log.Printf("Fault: returning %d for %s %s on route %s", rule.ErrorStatus, c.Request.Method, c.Request.URL.Path, route)
+			c.Header("X-Gateway-Fault-Injected", "error")
+			c.AbortWithStatusJSON(rule.ErrorStatus, gin.H{"message": "Injected fault"})
+			return
+		}
+
+		if rule.BandwidthBytesPerSec > 0 {
+			var limit time.Duration
+			if forced {
+				limit = maxHeaderThrottle
+			}
+			c.Writer = newThrottledWriter(c.Request.Context(), c.Writer, rule.BandwidthBytesPerSec, limit)
+		}
+		c.Next()
+	}
+}
+
+// sample draws a delay from the distribution
+func (l *Latency) sample() time.Duration {
+	var ms float64
+	switch l.Distribution {
+	case "uniform":
+		ms = float64(l.MinMs)
+		if l.MaxMs > l.MinMs {
+			ms += rand.Float64() * float64(l.MaxMs-l.MinMs)
+		}
+	case "normal":
+		ms = float64(l.MeanMs) + rand.NormFloat64()*float64(l.StddevMs)
+	case "exponential":
+		ms = rand.ExpFloat64() * float64(l.MeanMs)
+	default:
+		ms = float64(l.Ms)
+	}
+	return time.Duration(math.Max(ms, 0) * float64(time.Millisecond))
+}
+
+// parseHeader turns "latency=800ms; status=503; abort; bandwidth=20480" into a
+// rule that applies to every matching request
+func parseHeader(header string) (Rule, error) {
+	var rule Rule
+	for _, part := range strings.FieldsFunc(header, func(r rune) bool { return r == ';' || r == ',' }) {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch strings.ToLower(key) {
+		case "latency":
+			delay, err := time.ParseDuration(value)
+			if err != nil || delay < 0 || delay > maxHeaderLatency {
+				return Rule{}, fmt.Errorf("invalid %s latency %q, must be at most %s", HeaderName, value, maxHeaderLatency)
+			}
+			rule.Latency = &Latency{Ms: int(delay.Milliseconds()), Percent: 100}
+		case "status":
+			status, err := strconv.Atoi(value)
+			if err != nil || status < 400 || status > 599 {
+				return Rule{}, fmt.Errorf("invalid %s status %q", HeaderName, value)
+			}
+			rule.ErrorStatus, rule.ErrorPercent = status, 100
+		case "abort":
+			rule.AbortPercent = 100
+		case "bandwidth":
+			bytesPerSec, err := strconv.Atoi(value)
+			if err != nil || bytesPerSec < minHeaderBandwidth {
+				return Rule{}, fmt.Errorf("invalid %s bandwidth %q, must be at least %d bytes per second", HeaderName, value, minHeaderBandwidth)
+			}
+			rule.BandwidthBytesPerSec = bytesPerSec
+		default:
+			return Rule{}, fmt.Errorf("unknown %s fault %q", HeaderName, key)
+		}
+	}
+	return rule, nil
+}
+
+func chance(percent float64) bool {
+	return percent > 0 && rand.Float64()*100 < percent
+}
+
+// sleep waits for d, returning false if the client went away first
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// abort drops the connection without a response, like a crashed upstream would.
+// HTTP/2 streams can't be hijacked, those get a bare 502 instead.
+func abort(c *gin.Context) {
+	c.Abort()
+	conn, _, err := c.Writer.Hijack()
+	if err != nil {
+		c.Status(http.StatusBadGateway)
+		return
+	}
+	conn.Close()
+}
+
+// throttledWriter limits how fast the response body reaches the client. Past
+// the deadline, if any, the rest is written at full speed.
+type throttledWriter struct {
+	gin.ResponseWriter
+	ctx      context.Context
+	limiter  *rate.Limiter
+	deadline time.Time
+}
+
+func newThrottledWriter(ctx context.Context, w gin.ResponseWriter, bytesPerSec int, limit time.Duration) *throttledWriter {
+	writer := &throttledWriter{
+		ResponseWriter: w,
+		ctx:            ctx,
+		limiter:        rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec),
+	}
+	if limit > 0 {
+		writer.deadline = time.Now().Add(limit)
+	}
+	return writer
+}
+
+func (w *throttledWriter) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		if !w.deadline.IsZero() && time.Now().After(w.deadline) {
+			n, err := w.ResponseWriter.Write(data)
+			return written + n, err
+		}
+		chunk := len(data)
+		if burst := w.limiter.Burst(); chunk > burst {
+			chunk = burst
+		}
+		if err := w.limiter.WaitN(w.ctx, chunk); err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(data[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		w.ResponseWriter.Flush()
+		data = data[chunk:]
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
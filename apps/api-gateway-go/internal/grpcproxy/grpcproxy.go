@@ -0,0 +1,241 @@
+package grpcproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/http2"
+)
+
+// Route sends calls for a gRPC service (optionally a single method) to an upstream.
+// Upstream URLs use http:// for cleartext HTTP/2 (h2c) and https:// for TLS.
+type Route struct {
+	Service  string `json:"service"` // Fully qualified, e.g. recommendation.RecommendationService
+	Method   string `json:"method"`  // Optional, empty matches every method
+	Upstream string `json:"upstream"`
+}
+
+// LoadRoutes reads gRPC routes from a JSON file
+func LoadRoutes(path string) ([]Route, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gRPC routes file: %w", err)
+	}
+
+	var routes []Route
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("failed to parse gRPC routes file: %w", err)
+	}
+	return routes, nil
+}
+
+type target struct {
+	route     Route
+	url       *url.URL
+	transport http.RoundTripper
+	proxy     *httputil.ReverseProxy
+}
+
+// Proxy routes gRPC and gRPC-Web requests by service/method
+type Proxy struct {
+	targets []*target
+
+	mu    sync.Mutex
+	stats map[string]map[string]int64 // "/service/method" (or "/service/*") -> status name -> count
+}
+
+// New creates a gRPC proxy for the given routes. tlsConfig is used for https:// upstreams
+// and may be nil. wrap, when set, wraps each upstream transport (e.g. to sign calls).
+func New(routes []Route, tlsConfig *tls.Config, wrap func(http.RoundTripper) http.RoundTripper) (*Proxy, error) {
+	p := &Proxy{stats: make(map[string]map[string]int64)}
+
+	for _, route := range routes {
+		if route.Service == "" || route.Upstream == "" {
+			return nil, fmt.Errorf("gRPC route needs a service and an upstream")
+		}
+		targetURL, err := url.Parse(route.Upstream)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse gRPC upstream %s: %w", route.Upstream, err)
+		}
+
+		var transport http.RoundTripper = newTransport(targetURL, tlsConfig)
+		if wrap != nil {
+			transport = wrap(transport)
+		}
+
+		t := &target{
+			route:     route,
+			url:       targetURL,
+			transport: transport,
+		}
+		t.proxy = &httputil.ReverseProxy{
+			Director: func(req *http.Request) {
+				req.URL.Scheme = t.url.Scheme
+				req.URL.Host = t.url.Host
+			},
+			Transport:     t.transport,
+			FlushInterval: -1, // Stream every frame straight through
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				log.Printf("gRPC proxy error for %s: %v", r.URL.Path, err)
+				writeStatus(w, "application/grpc", codeUnavailable, "upstream unavailable")
+			},
+		}
+
+		p.targets = append(p.targets, t)
+		log.Printf("Registered gRPC route %s/%s -> %s", route.Service, route.Method, route.Upstream)
+	}
+
+	// Most specific routes (with a method) first
+	sort.SliceStable(p.targets, func(i, j int) bool {
+		return p.targets[i].route.Method != "" && p.targets[j].route.Method == ""
+	})
+
+	return p, nil
+}
+
+// newTransport speaks HTTP/2 to the upstream, in cleartext (h2c) for http:// targets
+func newTransport(targetURL *url.URL, tlsConfig *tls.Config) *http2.Transport {
+	if targetURL.Scheme == "https" {
+		return &http2.Transport{TLSClientConfig: tlsConfig}
+	}
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// Middleware serves requests with a gRPC or gRPC-Web content type and passes everything else on
+func (p *Proxy) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		contentType := c.GetHeader("Content-Type")
+		if !strings.HasPrefix(contentType, "application/grpc") {
+			c.Next()
+			return
+		}
+		c.Abort()
+
+		service, method := splitPath(c.Request.URL.Path)
+		t := p.match(service, method)
+		if t == nil {
+			writeStatus(c.Writer, responseContentType(contentType), codeUnimplemented, "unknown service "+service)
+			// Bucket unknown paths together so clients can't grow the stats map
+			p.record("unknown", codeUnimplemented, time.Now(), contentType)
+			return
+		}
+
+		start := time.Now()
+		var code string
+		if strings.HasPrefix(contentType, "application/grpc-web") {
+			code = p.serveGRPCWeb(c.Writer, c.Request, t)
+		} else {
+			code = p.serveGRPC(c.Writer, c.Request, t)
+		}
+		p.record(t.statsKey(), code, start, contentType)
+	}
+}
+
+// statsKey names the configured route rather than the request path, so
+// service-wide routes can't grow the stats map either
+func (t *target) statsKey() string {
+	method := t.route.Method
+	if method == "" {
+		method = "*"
+	}
+	return "/" + t.route.Service + "/" + method
+}
+
+// Stats returns call counts per route and gRPC status
+func (p *Proxy) Stats() map[string]map[string]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make(map[string]map[string]int64, len(p.stats))
+	for method, codes := range p.stats {
+		stats[method] = make(map[string]int64, len(codes))
+		for code, count := range codes {
+			stats[method][code] = count
+		}
+	}
+	return stats
+}
+
+// Routes returns the configured routes
+func (p *Proxy) Routes() []Route {
+	routes := make([]Route, 0, len(p.targets))
+	for _, t := range p.targets {
+		routes = append(routes, t.route)
+	}
+	return routes
+}
+
+func (p *Proxy) serveGRPC(w http.ResponseWriter, r *http.Request, t *target) string {
+	t.proxy.ServeHTTP(w, r)
+
+	// grpc-status arrives as a trailer, or as a header for trailers-only responses
+	if code := w.Header().Get("Grpc-Status"); code != "" {
+		return code
+	}
+	if code := w.Header().Get(http.TrailerPrefix + "Grpc-Status"); code != "" {
+		return code
+	}
+	return codeUnknown
+}
+
+func (p *Proxy) match(service, method string) *target {
+	for _, t := range p.targets {
+		if t.route.Service == service && (t.route.Method == "" || t.route.Method == method) {
+			return t
+		}
+	}
+	return nil
+}
+
+func (p *Proxy) record(path, code string, start time.Time, contentType string) {
+	name := statusName(code)
+
+	p.mu.Lock()
+	if p.stats[path] == nil {
+		p.stats[path] = make(map[string]int64)
+	}
+	p.stats[path][name]++
+	p.mu.Unlock()
+
+	log.Printf("gRPC %s %s status=%s (%s) in %v", contentType, path, code, name, time.Since(start))
+}
+
+// splitPath turns /package.Service/Method into its parts
+func splitPath(path string) (string, string) {
+	path = strings.TrimPrefix(path, "/")
+	if i := strings.LastIndexByte(path, '/'); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
+// writeStatus sends a trailers-only gRPC error response
+func writeStatus(w http.ResponseWriter, contentType, code, message string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Grpc-Status", code)
+	w.Header().Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
+}
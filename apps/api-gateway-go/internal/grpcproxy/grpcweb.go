@@ -0,0 +1,204 @@
+package grpcproxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// gRPC status codes used by the gateway itself
+const (
+	codeUnknown          = "2"
+	codeInternal         = "13"
+	codeUnimplemented    = "12"
+	codeUnavailable      = "14"
+	codeUnauthenticated  = "16"
+	codePermissionDenied = "7"
+)
+
+var statusNames = map[string]string{
+	"0":  "OK",
+	"1":  "CANCELLED",
+	"2":  "UNKNOWN",
+	"3":  "INVALID_ARGUMENT",
+	"4":  "DEADLINE_EXCEEDED",
+	"5":  "NOT_FOUND",
+	"6":  "ALREADY_EXISTS",
+	"7":  "PERMISSION_DENIED",
+	"8":  "RESOURCE_EXHAUSTED",
+	"9":  "FAILED_PRECONDITION",
+	"10": "ABORTED",
+	"11": "OUT_OF_RANGE",
+	"12": "UNIMPLEMENTED",
+	"13": "INTERNAL",
+	"14": "UNAVAILABLE",
+	"15": "DATA_LOSS",
+	"16": "UNAUTHENTICATED",
+}
+
+func statusName(code string) string {
+	if name, ok := statusNames[code]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// Headers that belong to the gRPC-Web hop and must not reach the gRPC upstream
+var grpcWebOnlyHeaders = []string{
+	"Accept",
+	"Connection",
+	"Content-Length",
+	"Content-Type",
+	"Keep-Alive",
+	"Upgrade",
+	"X-Grpc-Web",
+	"X-User-Agent",
+}
+
+// responseContentType mirrors the request's gRPC flavour back to the client
+func responseContentType(requestContentType string) string {
+	switch {
+	case strings.HasPrefix(requestContentType, "application/grpc-web-text"):
+		return "application/grpc-web-text+proto"
+	case strings.HasPrefix(requestContentType, "application/grpc-web"):
+		return "application/grpc-web+proto"
+	}
+	return "application/grpc"
+}
+
+// serveGRPCWeb translates a gRPC-Web call from a browser into native gRPC over
+// HTTP/2 and encodes the upstream trailers back into the response body
+func (p *Proxy) serveGRPCWeb(w http.ResponseWriter, r *http.Request, t *target) string {
+	requestContentType := r.Header.Get("Content-Type")
+	text := strings.HasPrefix(requestContentType, "application/grpc-web-text")
+	contentType := responseContentType(requestContentType)
+
+	var body io.Reader = r.Body
+	contentLength := r.ContentLength
+	if text {
+		body = base64.NewDecoder(base64.StdEncoding, r.Body)
+		contentLength = -1
+	}
+
+	upstreamURL := *t.url
+	upstreamURL.Path = r.URL.Path
+	upstreamURL.RawQuery = ""
+
+	out, err := http.NewRequestWithContext(r.Context(), http.MethodPost, upstreamURL.String(), body)
+	if err != nil {
+		writeStatus(w, contentType, codeInternal, err.Error())
+		return codeInternal
+	}
+	out.ContentLength = contentLength
+	out.Header = r.Header.Clone()
+	for _, name := range grpcWebOnlyHeaders {
+		out.Header.Del(name)
+	}
+	out.Header.Set("Content-Type", "application/grpc+proto")
+	out.Header.Set("Te", "trailers")
+
+	resp, err := t.transport.RoundTrip(out)
+	if err != nil {
+		log.Printf("gRPC-Web proxy error for %s: %v", r.URL.Path, err)
+		writeStatus(w, contentType, codeUnavailable, "upstream unavailable")
+		return codeUnavailable
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		code := codeFromHTTPStatus(resp.StatusCode)
+		writeStatus(w, contentType, code, fmt.Sprintf("upstream returned HTTP %d", resp.StatusCode))
+		return code
+	}
+
+	for name, values := range resp.Header {
+		if name == "Content-Type" || name == "Content-Length" || name == "Trailer" {
+			continue
+		}
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	write := func(data []byte) error {
+		if text {
+			data = []byte(base64.StdEncoding.EncodeToString(data))
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if err := write(buf[:n]); err != nil {
+				return codeUnavailable
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			log.Printf("gRPC-Web stream error for %s: %v", r.URL.Path, readErr)
+			return codeUnavailable
+		}
+	}
+
+	// Trailers-only responses already carried grpc-status in the headers
+	if resp.Trailer.Get("Grpc-Status") == "" {
+		if code := resp.Header.Get("Grpc-Status"); code != "" {
+			return code
+		}
+		resp.Trailer.Set("Grpc-Status", codeUnknown)
+	}
+
+	if err := write(trailerFrame(resp.Trailer)); err != nil {
+		return codeUnavailable
+	}
+	return resp.Trailer.Get("Grpc-Status")
+}
+
+// trailerFrame encodes trailers as a gRPC-Web body frame: flag 0x80, a 4 byte
+// big-endian length, then HTTP/1 style "name: value" lines
+func trailerFrame(trailer http.Header) []byte {
+	var lines bytes.Buffer
+	for name, values := range trailer {
+		for _, value := range values {
+			fmt.Fprintf(&lines, "%s: %s\r\n", strings.ToLower(name), value)
+		}
+	}
+
+	frame := make([]byte, 5, 5+lines.Len())
+	frame[0] = 0x80
+	binary.BigEndian.PutUint32(frame[1:], uint32(lines.Len()))
+	return append(frame, lines.Bytes()...)
+}
+
+// codeFromHTTPStatus follows the gRPC spec for mapping non-200 HTTP responses
+func codeFromHTTPStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return codeInternal
+	case http.StatusUnauthorized:
+		return codeUnauthenticated
+	case http.StatusForbidden:
+		return codePermissionDenied
+	case http.StatusNotFound:
+		return codeUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codeUnavailable
+	}
+	return codeUnknown
+}
@@ -0,0 +1,375 @@
+package maintenance
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eshop/api-gateway-go/internal/auth"
+	"github.com/eshop/api-gateway-go/internal/fileutil"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultMessage = "Service is under maintenance, please try again later."
+
+var ErrWindowNotFound = errors.New("maintenance window not found")
+
+// Mode is a manual maintenance switch, either global or for one route
+type Mode struct {
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message,omitempty"`
+	HTML       string `json:"html,omitempty"`       // Served to browsers instead of JSON when set
+	RetryAfter int    `json:"retryAfter,omitempty"` // Seconds
+}
+
+// Window is scheduled downtime. It is active between Start and End.
+type Window struct {
+	ID      string    `json:"id"`
+	Route   string    `json:"route,omitempty"` // Empty means every route
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Message string    `json:"message,omitempty"`
+	HTML    string    `json:"html,omitempty"`
+}
+
+// Bypass lists callers that are let through while maintenance is active
+type Bypass struct {
+	IPs   []string `json:"ips"`   // Single IPs or CIDR ranges
+	Users []string `json:"users"` // User or seller IDs
+}
+
+// State is the full maintenance configuration
+type State struct {
+	Global  Mode            `json:"global"`
+	Routes  map[string]Mode `json:"routes"`
+	Windows []Window        `json:"windows"`
+	Bypass  Bypass          `json:"bypass"`
+}
+
+// Manager holds maintenance state and can change it at runtime.
+// If a file path is configured, every change is written back to it.
+type Manager struct {
+	mu          sync.RWMutex
+	state       State
+	bypassNets  []*net.IPNet
+	bypassUsers map[string]bool
+
+	path              string
+	tokenSecret       string
+	defaultRetryAfter int
+}
+
+// NewManager creates a manager, loading initial state from path if it exists
+func NewManager(path, tokenSecret string, defaultRetryAfter int) (*Manager, error) {
+	m := &Manager{
+		state:             State{Routes: make(map[string]Mode)},
+		bypassUsers:       make(map[string]bool),
+		path:              path,
+		tokenSecret:       tokenSecret,
+		defaultRetryAfter: defaultRetryAfter,
+	}
+
+	if path == "" {
+		return m, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, fmt.Errorf("failed to read maintenance file: %w", err)
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse maintenance file: %w", err)
+	}
+	if state.Routes == nil {
+		state.Routes = make(map[string]Mode)
+	}
+	for _, w := range state.Windows {
+		if !w.End.After(w.Start) {
+			return nil, fmt.Errorf("maintenance window %s ends before it starts", w.ID)
+		}
+	}
+	if err := m.applyBypass(state.Bypass); err != nil {
+		return nil, err
+	}
+	m.state = state
+
+	log.Printf("Loaded maintenance state from %s (%d windows)", path, len(state.Windows))
+	return m, nil
+}
+
+// State returns a copy of the current state
+func (m *Manager) State() State {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	state := m.state
+	state.Routes = make(map[string]Mode, len(m.state.Routes))
+	for name, mode := range m.state.Routes {
+		state.Routes[name] = mode
+	}
+	state.Windows = append([]Window(nil), m.state.Windows...)
+	return state
+}
+
+// SetGlobal switches maintenance for every route
+func (m *Manager) SetGlobal(mode Mode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state.Global = mode
+	return m.saveLocked()
+}
+
+// SetRoute switches maintenance for a single route
+func (m *Manager) SetRoute(route string, mode Mode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state.Routes[route] = mode
+	return m.saveLocked()
+}
+
+// ClearRoute removes a route's manual maintenance setting
+func (m *Manager) ClearRoute(route string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.state.Routes, route)
+	return m.saveLocked()
+}
+
+// AddWindow schedules downtime. Windows that have already ended are dropped.
+func (m *Manager) AddWindow(w Window) (Window, error) {
+	if !w.End.After(w.Start) {
+		return Window{}, errors.New("end must be after start")
+	}
+
+	idBytes := make([]byte, 6)
+	if _, err := rand.Read(idBytes); err != nil {
+		return Window{}, err
+	}
+	w.ID = hex.EncodeToString(idBytes)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	windows := make([]Window, 0, len(m.state.Windows)+1)
+	for _, existing := range m.state.Windows {
+		if existing.End.After(now) {
+			windows = append(windows, existing)
+		}
+	}
+	m.state.Windows = append(windows, w)
+
+	return w, m.saveLocked()
+}
+
+// RemoveWindow cancels scheduled downtime
+func (m *Manager) RemoveWindow(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, w := range m.state.Windows {
+		if w.ID == id {
+			m.state.Windows = append(m.state.Windows[:i], m.state.Windows[i+1:]...)
+			return m.saveLocked()
+		}
+	}
+	return ErrWindowNotFound
+}
+
+// SetBypass replaces the bypass allow-list
+func (m *Manager) SetBypass(bypass Bypass) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.applyBypass(bypass); err != nil {
+		return err
+	}
+	m.state.Bypass = bypass
+	return m.saveLocked()
+}
+
+// InMaintenance reports whether the route is currently in maintenance
+func (m *Manager) InMaintenance(route string) bool {
+	_, ok := m.active(route, time.Now())
+	return ok
+}
+
+// Blocks reports whether maintenance on the route applies to the request,
+// i.e. it is active and the caller isn't on the bypass list
+func (m *Manager) Blocks(c *gin.Context, route string) bool {
+	_, ok := m.active(route, time.Now())
+	return ok && !m.bypassed(c)
+}
+
+// Middleware rejects requests to the route while maintenance is active
+func (m *Manager) Middleware(route string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now()
+		active, ok := m.active(route, now)
+		if !ok || m.bypassed(c) {
+			c.Next()
+			return
+		}
+
+		retryAfter := active.retryAfter
+		if !active.until.IsZero() {
+			retryAfter = int(math.Ceil(active.until.Sub(now).Seconds()))
+		}
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+
+		if active.html != "" && strings.Contains(c.GetHeader("Accept"), "text/html") {
+			c.Data(http.StatusServiceUnavailable, "text/html; charset=utf-8", []byte(active.html))
+			c.Abort()
+			return
+		}
+
+		body := gin.H{
+			"message":     active.message,
+			"maintenance": true,
+			"retryAfter":  retryAfter,
+		}
+		if !active.until.IsZero() {
+			body["until"] = active.until
+		}
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, body)
+	}
+}
+
+type activeMaintenance struct {
+	message    string
+	html       string
+	retryAfter int
+	until      time.Time
+}
+
+// active resolves what applies to a route: a manual route switch wins over the
+// global switch, which wins over scheduled windows
+func (m *Manager) active(route string, now time.Time) (activeMaintenance, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if mode, ok := m.state.Routes[route]; ok && mode.Enabled {
+		return m.fromMode(mode), true
+	}
+	if m.state.Global.Enabled {
+		return m.fromMode(m.state.Global), true
+	}
+	for _, w := range m.state.Windows {
+		if (w.Route == "" || w.Route == route) && !now.Before(w.Start) && now.Before(w.End) {
+			message := w.Message
+			if message == "" {
+				message = defaultMessage
+			}
+			return activeMaintenance{message: message, html: w.HTML, until: w.End}, true
+		}
+	}
+	return activeMaintenance{}, false
+}
+
+func (m *Manager) fromMode(mode Mode) activeMaintenance {
+	active := activeMaintenance{
+		message:    mode.Message,
+		html:       mode.HTML,
+		retryAfter: mode.RetryAfter,
+	}
+	if active.message == "" {
+		active.message = defaultMessage
+	}
+	if active.retryAfter <= 0 {
+		active.retryAfter = m.defaultRetryAfter
+	}
+	return active
+}
+
+func (m *Manager) bypassed(c *gin.Context) bool {
+	m.mu.RLock()
+	nets := m.bypassNets
+	users := m.bypassUsers
+	m.mu.RUnlock()
+
+	if ip := net.ParseIP(c.ClientIP()); ip != nil {
+		for _, ipNet := range nets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	if len(users) == 0 {
+		return false
+	}
+	// Set by APIKeyAuth, never trusted from the client
+	if sellerID := c.Request.Header.Get("X-Seller-Id"); sellerID != "" && users[sellerID] {
+		return true
+	}
+	if identity, err := auth.Identify(c.Request, m.tokenSecret); err == nil && users[identity.ID] {
+		return true
+	}
+	return false
+}
+
+// applyBypass parses the allow-list. Caller must hold m.mu (or be constructing m).
+func (m *Manager) applyBypass(bypass Bypass) error {
+	nets := make([]*net.IPNet, 0, len(bypass.IPs))
+	for _, entry := range bypass.IPs {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid bypass IP %q: %w", entry, err)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	users := make(map[string]bool, len(bypass.Users))
+	for _, user := range bypass.Users {
+		users[user] = true
+	}
+
+	m.bypassNets = nets
+	m.bypassUsers = users
+	return nil
+}
+
+// saveLocked writes the state file. Caller must hold m.mu.
+func (m *Manager) saveLocked() error {
+	if m.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(m.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fileutil.WriteAtomic(m.path, data); err != nil {
+		return fmt.Errorf("failed to save maintenance state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/eshop/api-gateway-go/internal/apikey"
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyContextKey is the gin context key holding the authenticated *apikey.Key
+const APIKeyContextKey = "apiKey"
+
+// APIKeyAuth authenticates machine clients that send an API key.
+// Requests without a key pass through untouched so cookie/JWT auth keeps working upstream.
+func APIKeyAuth(store *apikey.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Identity headers are only ever set by the gateway
+		c.Request.Header.Del("X-Seller-Id")
+		c.Request.Header.Del("X-Api-Key-Id")
+
+		raw := extractAPIKey(c.Request)
+		if raw == "" {
+			c.Next()
+			return
+		}
+
+		key, err := store.Authenticate(raw)
+		if err != nil {
+			message := "Invalid API key"
+			if errors.Is(err, apikey.ErrExpired) {
+				message = "API key expired"
+			} else if errors.Is(err, apikey.ErrRevoked) {
+				message = "API key revoked"
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": message})
+			return
+		}
+
+		if !key.AllowsPath(c.Request.URL.Path) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": "API key is not allowed to access this route",
+			})
+			return
+		}
+
+		if !store.Allow(key) {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"message": "Too many requests, please try again later.",
+			})
+			return
+		}
+
+		// Don't leak the secret to upstreams, forward the resolved identity instead
+		c.Request.Header.Del("X-API-Key")
+		if strings.HasPrefix(c.Request.Header.Get("Authorization"), "ApiKey ") {
+			c.Request.Header.Del("Authorization")
+		}
+		c.Request.Header.Set("X-Seller-Id", key.SellerID)
+		c.Request.Header.Set("X-Api-Key-Id", key.ID)
+
+		c.Set(APIKeyContextKey, key)
+		c.Next()
+	}
+}
+
+// AdminAuth protects gateway management endpoints with a static bearer token
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func extractAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "ApiKey ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "ApiKey "))
+	}
+	return ""
+}
@@ -9,9 +9,14 @@ import (
 
 func CORS(origins []string) gin.HandlerFunc {
 	config := cors.Config{
-		AllowOrigins:     origins,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowHeaders:     []string{"Content-Type", "Authorization", "X-Requested-With", "X-User-Id", "Accept", "Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		AllowOrigins: origins,
+		AllowMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
+		AllowHeaders: []string{"Content-Type", "Authorization", "X-Requested-With", "X-User-Id", "X-API-Key", "Accept", "Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers",
+			// gRPC-Web
+			"X-Grpc-Web", "X-User-Agent", "Grpc-Timeout",
+			// Fault injection from UI tests, when GATEWAY_FAULT_HEADER_ENABLED is set
+			"X-Gateway-Fault"},
+		ExposeHeaders:    []string{"Grpc-Status", "Grpc-Message", "Retry-After"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"github.com/eshop/api-gateway-go/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// UserIdentity replaces any client-supplied X-User-Id with the caller from a
+// verified access token. Upstreams trust the header because the gateway signs
+// it, so it must never come from the client.
+func UserIdentity(tokenSecret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Header.Del("X-User-Id")
+		if identity, err := auth.Identify(c.Request, tokenSecret); err == nil {
+			c.Request.Header.Set("X-User-Id", identity.ID)
+		}
+		c.Next()
+	}
+}
@@ -54,16 +54,50 @@ func (i *IPRateLimiter) GetLimiter(ip string) *rate.Limiter {
 	return limiter
 }
 
-// RateLimitMiddleware creates a Gin middleware for rate limiting
-func RateLimitMiddleware() gin.HandlerFunc {
+// RateLimiterStats is a point-in-time view of an IPRateLimiter
+type RateLimiterStats struct {
+	TrackedClients int     `json:"trackedClients"`
+	Rate           float64 `json:"rate"`
+	Burst          int     `json:"burst"`
+}
+
+// Stats returns the limiter's configuration and the number of tracked IPs
+func (i *IPRateLimiter) Stats() RateLimiterStats {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return RateLimiterStats{
+		TrackedClients: len(i.ips),
+		Rate:           float64(i.r),
+		Burst:          i.b,
+	}
+}
+
+// Purge drops all per-IP buckets, giving every client a fresh allowance
+func (i *IPRateLimiter) Purge() {
+	i.mu.Lock()
+	i.ips = make(map[string]*rate.Limiter)
+	i.mu.Unlock()
+}
+
+// NewDefaultIPRateLimiter creates the gateway's global per-IP limiter
+func NewDefaultIPRateLimiter() *IPRateLimiter {
 	// Limit: 100 requests per 15 minutes (approx 0.11 req/sec)
 	// Burst: 100
 	// Note: The original TS implementation had 100 req/15min window.
 	// rate.Limit is req/sec. 100 / (15 * 60) = 0.111
+	return NewIPRateLimiter(rate.Limit(0.12), 100)
+}
 
-	limiter := NewIPRateLimiter(rate.Limit(0.12), 100)
-
+// RateLimitMiddleware creates a Gin middleware for rate limiting
+func RateLimitMiddleware(limiter *IPRateLimiter) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// API key clients are limited per key by APIKeyAuth instead
+		if _, ok := c.Get(APIKeyContextKey); ok {
+			c.Next()
+			return
+		}
+
 		ip := c.ClientIP()
 		if !limiter.GetLimiter(ip).Allow() {
 			c.JSON(http.StatusTooManyRequests, gin.H{
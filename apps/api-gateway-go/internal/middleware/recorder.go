@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"bytes"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResponseRecorder passes the response through to the client and keeps a copy
+// of the body, up to a limit
+type ResponseRecorder struct {
+	gin.ResponseWriter
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+// NewResponseRecorder wraps w, keeping at most limit bytes of the body
+func NewResponseRecorder(w gin.ResponseWriter, limit int) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, limit: limit}
+}
+
+func (r *ResponseRecorder) Write(data []byte) (int, error) {
+	r.record(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *ResponseRecorder) WriteString(s string) (int, error) {
+	r.record([]byte(s))
+	return r.ResponseWriter.WriteString(s)
+}
+
+// Body returns the recorded body, empty if it was truncated
+func (r *ResponseRecorder) Body() []byte {
+	return r.buf.Bytes()
+}
+
+// Truncated reports whether the body went over the limit and was dropped
+func (r *ResponseRecorder) Truncated() bool {
+	return r.truncated
+}
+
+func (r *ResponseRecorder) record(data []byte) {
+	if r.truncated {
+		return
+	}
+	if r.buf.Len()+len(data) > r.limit {
+		r.truncated = true
+		r.buf.Reset()
+		return
+	}
+	r.buf.Write(data)
+}
@@ -0,0 +1,279 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// maxRequestBody caps how much of a request body is read for templating
+const maxRequestBody = 1 << 20
+
+// Fixture is a recorded request/response pair. Files may hold one fixture or an array.
+type Fixture struct {
+	Request struct {
+		Method  string            `json:"method"`  // Empty matches any method
+		Path    string            `json:"path"`    // Upstream path; :name captures a segment, a final * the rest
+		Query   map[string]string `json:"query"`   // Values that must be present
+		Headers map[string]string `json:"headers"` // Values that must be present
+	} `json:"request"`
+	Response struct {
+		Status   int               `json:"status"` // Defaults to 200
+		Headers  map[string]string `json:"headers"`
+		Body     json.RawMessage   `json:"body"`     // JSON, or a JSON string for other content
+		BodyFile string            `json:"bodyFile"` // Relative to the fixture file, used instead of body
+	} `json:"response"`
+	LatencyMs int `json:"latencyMs"`
+	JitterMs  int `json:"jitterMs"` // Up to this much is added to the latency at random
+
+	file     string
+	segments []string
+	body     *template.Template
+}
+
+// Transport serves an upstream from a fixture directory instead of the network.
+// It plugs into proxy.NewUpstream, so routing, breakers and stats work unchanged.
+type Transport struct {
+	fixtures []*Fixture
+}
+
+// Load reads every *.json fixture under dir, in lexical order. The first fixture
+// that matches a request wins. Directories starting with "_" are skipped so they
+// can hold body files.
+func Load(dir string) (*Transport, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != dir && strings.HasPrefix(d.Name(), "_") {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".json" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mock fixtures in %s: %w", dir, err)
+	}
+	sort.Strings(files)
+
+	t := &Transport{}
+	for _, file := range files {
+		fixtures, err := loadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		t.fixtures = append(t.fixtures, fixtures...)
+	}
+	return t, nil
+}
+
+// Fixtures returns how many fixtures were loaded
+func (t *Transport) Fixtures() int {
+	return len(t.fixtures)
+}
+
+func loadFile(file string) ([]*Fixture, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mock fixture: %w", err)
+	}
+
+	var fixtures []*Fixture
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(data, &fixtures)
+	} else {
+		fixture := &Fixture{}
+		err = json.Unmarshal(data, fixture)
+		fixtures = []*Fixture{fixture}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse mock fixture %s: %w", file, err)
+	}
+
+	for i, fixture := range fixtures {
+		if fixture.Request.Path == "" {
+			return nil, fmt.Errorf("mock fixture %s[%d] needs a request path", file, i)
+		}
+		fixture.file = file
+		fixture.segments = splitPath(fixture.Request.Path)
+
+		body, err := fixture.bodyText()
+		if err != nil {
+			return nil, err
+		}
+		fixture.body, err = template.New(file).Funcs(templateFuncs).Parse(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse body template in %s: %w", file, err)
+		}
+	}
+	return fixtures, nil
+}
+
+// bodyText returns the raw body template: a body file, the contents of a JSON
+// string, or the JSON itself
+func (f *Fixture) bodyText() (string, error) {
+	if f.Response.BodyFile != "" {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(f.file), f.Response.BodyFile))
+		if err != nil {
+			return "", fmt.Errorf("failed to read mock body file for %s: %w", f.file, err)
+		}
+		return string(data), nil
+	}
+	if len(f.Response.Body) == 0 {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(f.Response.Body, &text); err == nil {
+		return text, nil
+	}
+	return string(f.Response.Body), nil
+}
+
+// RoundTrip answers from the first matching fixture, or 404 when none matches
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(req.Body, maxRequestBody))
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fixture, params := t.match(req)
+	if fixture == nil {
+		message, _ := json.Marshal(map[string]string{
+			"message": fmt.Sprintf("No mock fixture for %s %s", req.Method, req.URL.Path),
+		})
+		return newResponse(req, http.StatusNotFound, http.Header{"Content-Type": {"application/json"}}, message), nil
+	}
+
+	if err := fixture.wait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	var rendered bytes.Buffer
+	if err := fixture.body.Execute(&rendered, newTemplateData(req, params, body)); err != nil {
+		return nil, fmt.Errorf("mock fixture %s: %w", fixture.file, err)
+	}
+
+	header := make(http.Header)
+	for name, value := range fixture.Response.Headers {
+		header.Set(name, value)
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	status := fixture.Response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return newResponse(req, status, header, rendered.Bytes()), nil
+}
+
+func (t *Transport) match(req *http.Request) (*Fixture, map[string]string) {
+	segments := splitPath(req.URL.Path)
+	query := req.URL.Query()
+
+	for _, fixture := range t.fixtures {
+		if fixture.Request.Method != "" && !strings.EqualFold(fixture.Request.Method, req.Method) {
+			continue
+		}
+		params, ok := matchSegments(fixture.segments, segments)
+		if !ok {
+			continue
+		}
+		if !matchValues(fixture.Request.Query, query.Get) || !matchValues(fixture.Request.Headers, req.Header.Get) {
+			continue
+		}
+		return fixture, params
+	}
+	return nil, nil
+}
+
+// wait applies the fixture's simulated latency, giving up if the caller does
+func (f *Fixture) wait(ctx context.Context) error {
+	delay := time.Duration(f.LatencyMs) * time.Millisecond
+	if f.JitterMs > 0 {
+		delay += time.Duration(rand.Intn(f.JitterMs+1)) * time.Millisecond
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func matchSegments(pattern, segments []string) (map[string]string, bool) {
+	params := make(map[string]string)
+	for i, part := range pattern {
+		if part == "*" && i == len(pattern)-1 {
+			params["*"] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		if strings.HasPrefix(part, ":") {
+			params[part[1:]] = segments[i]
+			continue
+		}
+		if part != segments[i] {
+			return nil, false
+		}
+	}
+	return params, len(pattern) == len(segments)
+}
+
+func matchValues(want map[string]string, get func(string) string) bool {
+	for name, value := range want {
+		if get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func newResponse(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,76 @@
+package mock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	mathrand "math/rand"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// templateData is what response bodies can refer to, e.g. {{.Params.id}} or {{.Query.page}}
+type templateData struct {
+	Method  string
+	Path    string
+	Params  map[string]string
+	Query   map[string]string
+	Headers map[string]string
+	Body    interface{} // Decoded JSON request body, nil otherwise
+}
+
+func newTemplateData(req *http.Request, params map[string]string, body []byte) templateData {
+	data := templateData{
+		Method:  req.Method,
+		Path:    req.URL.Path,
+		Params:  params,
+		Query:   make(map[string]string),
+		Headers: make(map[string]string),
+	}
+	for name, values := range req.URL.Query() {
+		data.Query[name] = values[0]
+	}
+	for name, values := range req.Header {
+		data.Headers[name] = values[0]
+	}
+	if len(body) > 0 {
+		json.Unmarshal(body, &data.Body)
+	}
+	return data
+}
+
+var templateFuncs = template.FuncMap{
+	// now returns the current time in RFC 3339
+	"now": func() string {
+		return time.Now().UTC().Format(time.RFC3339)
+	},
+	// uuid returns a random version 4 UUID
+	"uuid": func() string {
+		b := make([]byte, 16)
+		rand.Read(b)
+		b[6] = (b[6] & 0x0f) | 0x40
+		b[8] = (b[8] & 0x3f) | 0x80
+		h := hex.EncodeToString(b)
+		return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+	},
+	// randInt returns a number in [min, max]
+	"randInt": func(min, max int) int {
+		if max <= min {
+			return min
+		}
+		return min + mathrand.Intn(max-min+1)
+	},
+	// json encodes a value, so strings come out quoted and escaped
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	// default returns fallback when value is empty
+	"default": func(fallback, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return fallback
+		}
+		return value
+	},
+}
@@ -0,0 +1,303 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/eshop/api-gateway-go/internal/middleware"
+	"github.com/eshop/api-gateway-go/internal/proxy"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/gin-gonic/gin"
+)
+
+// maxRecordedBody caps how much of a sampled response is kept for validation
+const maxRecordedBody = 1 << 20
+
+// Violation is one reason a request did not match the upstream's contract
+type Violation struct {
+	In      string `json:"in"`              // path, query, header, cookie or body
+	Field   string `json:"field,omitempty"` // Parameter name or JSON pointer into the body
+	Message string `json:"message"`
+}
+
+// Validator checks traffic against the OpenAPI 3 documents of the upstreams
+type Validator struct {
+	routers            map[string]routers.Router // Upstream name -> router over its spec
+	responseSampleRate float64
+	maxBodySize        int64
+}
+
+// Load reads a JSON file mapping upstream names to OpenAPI 3 documents (JSON or
+// YAML). responseSampleRate is the fraction of responses checked in log-only mode.
+// Request bodies over maxBodySize bytes are passed on without schema validation.
+func Load(path string, responseSampleRate float64, maxBodySize int64) (*Validator, error) {
+	v := &Validator{
+		routers:            make(map[string]routers.Router),
+		responseSampleRate: responseSampleRate,
+		maxBodySize:        maxBodySize,
+	}
+	if path == "" {
+		return v, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenAPI specs file: %w", err)
+	}
+	var specs map[string]string
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI specs file: %w", err)
+	}
+
+	for upstream, specPath := range specs {
+		loader := openapi3.NewLoader()
+		loader.IsExternalRefsAllowed = true
+		doc, err := loader.LoadFromFile(specPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load OpenAPI spec for %s: %w", upstream, err)
+		}
+		if err := doc.Validate(loader.Context); err != nil {
+			return nil, fmt.Errorf("invalid OpenAPI spec for %s: %w", upstream, err)
+		}
+
+		// Only the base path of each server matters, the gateway's own host differs
+		for _, server := range doc.Servers {
+			server.URL = serverPath(server.URL)
+		}
+
+		router, err := legacy.NewRouter(doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build router for OpenAPI spec of %s: %w", upstream, err)
+		}
+		v.routers[upstream] = router
+		log.Printf("Loaded OpenAPI spec for upstream %s from %s (%d paths)", upstream, specPath, len(doc.Paths))
+	}
+	return v, nil
+}
+
+// Middleware validates requests on a route against its upstream's spec. Paths
+// are matched as the upstream sees them, after the route prefix is stripped.
+// Operations missing from the spec are passed through unchecked.
+func (v *Validator) Middleware(route *proxy.Route) gin.HandlerFunc {
+	router := v.routers[route.Upstream.Name]
+	if router == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+	stripPrefix := ""
+	if route.StripPrefix {
+		stripPrefix = route.Prefix
+	}
+
+	return func(c *gin.Context) {
+		// WebSocket upgrades have no contract to check
+		if c.GetHeader("Upgrade") != "" {
+			c.Next()
+			return
+		}
+
+		req := upstreamRequest(c.Request, stripPrefix)
+		specRoute, pathParams, err := router.FindRoute(req)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		// The validator buffers the whole body, so only bounded bodies are checked
+		bounded, err := bufferBody(c.Request, v.maxBodySize)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Failed to read request body"})
+			return
+		}
+		req.Body = c.Request.Body
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: pathParams,
+			Route:      specRoute,
+			Options: &openapi3filter.Options{
+				MultiError:         true,
+				ExcludeRequestBody: !bounded,
+				// Credentials are checked by the services themselves
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		}
+		err = openapi3filter.ValidateRequest(c.Request.Context(), input)
+
+		// Body validation consumes the body and leaves a fresh reader on the copy
+		c.Request.Body = req.Body
+
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "Request validation failed",
+				"errors":  violations(err),
+			})
+			return
+		}
+
+		if v.responseSampleRate <= 0 || rand.Float64() >= v.responseSampleRate {
+			c.Next()
+			return
+		}
+
+		recorder := middleware.NewResponseRecorder(c.Writer, maxRecordedBody)
+		c.Writer = recorder
+		c.Next()
+		c.Writer = recorder.ResponseWriter
+
+		v.checkResponse(c.Request.Context(), route.Upstream.Name, input, recorder)
+	}
+}
+
+// checkResponse logs where a sampled response drifts from the spec. It never
+// affects the client, the response has already been sent.
+func (v *Validator) checkResponse(ctx context.Context, upstream string, input *openapi3filter.RequestValidationInput, recorder *middleware.ResponseRecorder) {
+	if recorder.Truncated() {
+		return
+	}
+	if encoding := recorder.Header().Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return
+	}
+
+	err := openapi3filter.ValidateResponse(ctx, &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 recorder.Status(),
+		Header:                 recorder.Header(),
+		Body:                   io.NopCloser(bytes.NewReader(recorder.Body())),
+		Options:                &openapi3filter.Options{MultiError: true},
+	})
+	if err == nil {
+		return
+	}
+
+	for _, violation := range violations(err) {
+		log.Printf("OpenAPI: response drift on %s %s %s (status %d): %s %s: %s",
+			upstream, input.Request.Method, input.Route.Path, recorder.Status(),
+			violation.In, violation.Field, violation.Message)
+	}
+}
+
+// bufferBody reads up to limit bytes of r's body and reports whether that was all
+// of it. Either way r.Body is replaced so the full body can still be read.
+func bufferBody(r *http.Request, limit int64) (bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return true, nil
+	}
+	if r.ContentLength > limit {
+		return false, nil
+	}
+
+	buffered, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return false, err
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buffered), r.Body), r.Body}
+	return int64(len(buffered)) <= limit, nil
+}
+
+// upstreamRequest returns a shallow copy of r with the path the upstream will receive
+func upstreamRequest(r *http.Request, stripPrefix string) *http.Request {
+	req := *r
+	u := *r.URL
+	u.Path = strings.TrimPrefix(u.Path, stripPrefix)
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawPath = ""
+	req.URL = &u
+	return &req
+}
+
+// serverPath reduces a server URL such as https://{host}/api/v1 to /api/v1
+func serverPath(serverURL string) string {
+	if i := strings.Index(serverURL, "://"); i >= 0 {
+		serverURL = serverURL[i+3:]
+		slash := strings.IndexByte(serverURL, '/')
+		if slash < 0 {
+			return "/"
+		}
+		serverURL = serverURL[slash:]
+	}
+	if serverURL == "" {
+		return "/"
+	}
+	return serverURL
+}
+
+// violations flattens the validation errors into a list clients can act on
+func violations(err error) []Violation {
+	var result []Violation
+	for _, err := range flatten(err) {
+		switch e := err.(type) {
+		case *openapi3filter.RequestError:
+			switch {
+			case e.Parameter != nil:
+				for _, cause := range causes(e.Reason, e.Err) {
+					result = append(result, Violation{In: e.Parameter.In, Field: e.Parameter.Name, Message: cause.Message})
+				}
+			case e.RequestBody != nil:
+				result = append(result, causes(e.Reason, e.Err)...)
+			default:
+				result = append(result, Violation{In: "request", Message: e.Error()})
+			}
+		case *openapi3filter.ResponseError:
+			result = append(result, causes(e.Reason, e.Err)...)
+		default:
+			result = append(result, Violation{In: "request", Message: err.Error()})
+		}
+	}
+	return result
+}
+
+// causes turns the schema errors behind a request or response error into body violations
+func causes(reason string, err error) []Violation {
+	if err == nil {
+		return []Violation{{In: "body", Message: reason}}
+	}
+
+	var result []Violation
+	for _, err := range flatten(err) {
+		var schemaErr *openapi3.SchemaError
+		if errors.As(err, &schemaErr) {
+			field := ""
+			if pointer := schemaErr.JSONPointer(); len(pointer) > 0 {
+				field = "/" + strings.Join(pointer, "/")
+			}
+			result = append(result, Violation{In: "body", Field: field, Message: schemaErr.Reason})
+			continue
+		}
+		if reason != "" {
+			result = append(result, Violation{In: "body", Message: reason + ": " + err.Error()})
+		} else {
+			result = append(result, Violation{In: "body", Message: err.Error()})
+		}
+	}
+	return result
+}
+
+// flatten expands nested MultiErrors into a single list
+func flatten(err error) []error {
+	multi, ok := err.(openapi3.MultiError)
+	if !ok {
+		return []error{err}
+	}
+	var result []error
+	for _, e := range multi {
+		result = append(result, flatten(e)...)
+	}
+	return result
+}
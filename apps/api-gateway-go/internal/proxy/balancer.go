@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// balancer hands out targets round-robin from a set that service discovery
+// can replace at runtime
+type balancer struct {
+	targets atomic.Pointer[[]*url.URL]
+	next    atomic.Uint64
+}
+
+// pick returns the next target, or nil when there are none
+func (b *balancer) pick() *url.URL {
+	targets := b.targets.Load()
+	if targets == nil || len(*targets) == 0 {
+		return nil
+	}
+	return (*targets)[(b.next.Add(1)-1)%uint64(len(*targets))]
+}
+
+func (b *balancer) set(targets []*url.URL) {
+	b.targets.Store(&targets)
+}
+
+func (b *balancer) list() []*url.URL {
+	if targets := b.targets.Load(); targets != nil {
+		return *targets
+	}
+	return nil
+}
+
+type targetKey struct{}
+
+type picked struct {
+	target  *url.URL
+	breaker *CircuitBreaker
+}
+
+// withTarget records the target picked for a proxied request so the director
+// sends it to the same place the handler checked, and the result is counted
+// against that target's breaker
+func withTarget(ctx context.Context, target *url.URL, breaker *CircuitBreaker) context.Context {
+	return context.WithValue(ctx, targetKey{}, picked{target: target, breaker: breaker})
+}
+
+func targetFrom(ctx context.Context) *url.URL {
+	p, _ := ctx.Value(targetKey{}).(picked)
+	return p.target
+}
+
+func breakerFrom(ctx context.Context) *CircuitBreaker {
+	p, _ := ctx.Value(targetKey{}).(picked)
+	return p.breaker
+}
+
+// rewriteURL points req at target the way httputil.NewSingleHostReverseProxy does
+func rewriteURL(reqURL, target *url.URL) {
+	reqURL.Scheme = target.Scheme
+	reqURL.Host = target.Host
+	reqURL.Path, reqURL.RawPath = joinURLPath(target, reqURL)
+	if target.RawQuery == "" || reqURL.RawQuery == "" {
+		reqURL.RawQuery = target.RawQuery + reqURL.RawQuery
+	} else {
+		reqURL.RawQuery = target.RawQuery + "&" + reqURL.RawQuery
+	}
+}
+
+func joinURLPath(a, b *url.URL) (string, string) {
+	if a.RawPath == "" && b.RawPath == "" {
+		return singleJoiningSlash(a.Path, b.Path), ""
+	}
+	apath := a.EscapedPath()
+	bpath := b.EscapedPath()
+
+	aslash := strings.HasSuffix(apath, "/")
+	bslash := strings.HasPrefix(bpath, "/")
+
+	switch {
+	case aslash && bslash:
+		return a.Path + b.Path[1:], apath + bpath[1:]
+	case !aslash && !bslash:
+		return a.Path + "/" + b.Path, apath + "/" + bpath
+	}
+	return a.Path + b.Path, apath + bpath
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -1,54 +1,365 @@
 package proxy
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// NewReverseProxy creates a reverse proxy handler for a given target URL
-// stripPrefix is optional. If provided, it will be removed from the request path before proxying.
-func NewReverseProxy(target string, stripPrefix string) gin.HandlerFunc {
-	url, err := url.Parse(target)
+// Upstream is a backend service the gateway proxies to. It tracks request
+// stats, and optionally a circuit breaker per target, so the admin API can
+// report on it. Requests are balanced round-robin over its targets.
+type Upstream struct {
+	Name string
+
+	balancer balancer
+	proxy    *httputil.ReverseProxy
+	client   *http.Client
+
+	breakerOptions BreakerOptions
+	breakersMu     sync.Mutex
+	breakers       map[string]*CircuitBreaker // Target URL -> breaker
+
+	requests atomic.Int64
+	failures atomic.Int64
+	inFlight atomic.Int64
+	draining atomic.Bool
+
+	mu        sync.RWMutex
+	lastError string
+	lastErrAt time.Time
+}
+
+// UpstreamStats is a point-in-time view of an upstream
+type UpstreamStats struct {
+	Name        string     `json:"name"`
+	Targets     []string   `json:"targets"`
+	Requests    int64      `json:"requests"`
+	Failures    int64      `json:"failures"`
+	InFlight    int64      `json:"inFlight"`
+	Draining    bool       `json:"draining"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+	// Per target, only when breakers are enabled
+	Breakers map[string]BreakerStats `json:"circuitBreakers,omitempty"`
+}
+
+// BreakerOptions configures the per-target circuit breakers. A zero Threshold
+// disables them.
+type BreakerOptions struct {
+	Threshold int // Consecutive failures before a target is taken out
+	Cooldown  time.Duration
+}
+
+// NewUpstream creates an upstream for the given target URL. SetTargets replaces
+// it, e.g. from service discovery. transport may be nil to use http.DefaultTransport.
+func NewUpstream(name, target string, transport http.RoundTripper, breakerOptions BreakerOptions) (*Upstream, error) {
+	targetURL, err := url.Parse(target)
 	if err != nil {
-		log.Fatalf("Failed to parse proxy target URL: %v", err)
+		return nil, fmt.Errorf("failed to parse proxy target URL for %s: %w", name, err)
 	}
 
-	proxy := httputil.NewSingleHostReverseProxy(url)
+	u := &Upstream{
+		Name:           name,
+		client:         &http.Client{Transport: transport},
+		breakerOptions: breakerOptions,
+		breakers:       make(map[string]*CircuitBreaker),
+	}
+	u.balancer.set([]*url.URL{targetURL})
 
-	// Custom director to set headers if needed
-	originalDirector := proxy.Director
-	proxy.Director = func(req *http.Request) {
-		originalDirector(req)
-		req.Header.Set("X-Forwarded-Host", req.Header.Get("Host"))
+	u.proxy = &httputil.ReverseProxy{Transport: transport}
 
-		// Strip prefix if configured
-		if stripPrefix != "" {
-			// Handle both Path and RawPath
-			req.URL.Path = strings.TrimPrefix(req.URL.Path, stripPrefix)
-			if req.URL.RawPath != "" {
-				req.URL.RawPath = strings.TrimPrefix(req.URL.RawPath, stripPrefix)
-			}
+	// The handler picks the target, the director points the request at it
+	u.proxy.Director = func(req *http.Request) {
+		rewriteURL(req.URL, targetFrom(req.Context()))
+		if _, ok := req.Header["User-Agent"]; !ok {
+			// Explicitly disable the default Go User-Agent
+			req.Header.Set("User-Agent", "")
 		}
+		req.Header.Set("X-Forwarded-Host", req.Header.Get("Host"))
 
 		// Debug logging
-		// log.Printf("Proxying request: %s %s -> %s%s", req.Method, req.RequestURI, url.String(), req.URL.Path)
+		// log.Printf("Proxying request: %s %s -> %s%s", req.Method, req.RequestURI, targetURL.String(), req.URL.Path)
 	}
 
 	// Modify response to strip downstream CORS headers
-	proxy.ModifyResponse = func(resp *http.Response) error {
+	u.proxy.ModifyResponse = func(resp *http.Response) error {
 		resp.Header.Del("Access-Control-Allow-Origin")
 		resp.Header.Del("Access-Control-Allow-Credentials")
 		resp.Header.Del("Access-Control-Allow-Methods")
 		resp.Header.Del("Access-Control-Allow-Headers")
+
+		u.recordResponse(breakerFrom(resp.Request.Context()), resp.StatusCode)
 		return nil
 	}
 
+	u.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		u.recordError(breakerFrom(r.Context()), err)
+		log.Printf("Proxy error for %s: %v", u.Name, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	return u, nil
+}
+
+// Handler returns a gin handler that proxies to this upstream.
+// stripPrefix is optional. If provided, it will be removed from the request path before proxying.
+func (u *Upstream) Handler(stripPrefix string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		proxy.ServeHTTP(c.Writer, c.Request)
+		if u.draining.Load() {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"message": "Service is draining, please try again later.",
+			})
+			return
+		}
+		target, breaker := u.pick()
+		if target == nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"message": "Service temporarily unavailable, please try again later.",
+			})
+			return
+		}
+
+		// Strip prefix if configured
+		if stripPrefix != "" {
+			// Handle both Path and RawPath
+			c.Request.URL.Path = strings.TrimPrefix(c.Request.URL.Path, stripPrefix)
+			if c.Request.URL.RawPath != "" {
+				c.Request.URL.RawPath = strings.TrimPrefix(c.Request.URL.RawPath, stripPrefix)
+			}
+		}
+
+		u.requests.Add(1)
+		u.inFlight.Add(1)
+		defer u.inFlight.Add(-1)
+
+		u.proxy.ServeHTTP(c.Writer, c.Request.WithContext(withTarget(c.Request.Context(), target, breaker)))
+	}
+}
+
+// ErrUnavailable is returned by Fetch when the upstream is draining or every target's breaker is open
+var ErrUnavailable = errors.New("upstream unavailable")
+
+// Fetch sends a request built by the gateway itself (rather than proxied from a client)
+// to the upstream, honouring draining and the circuit breaker. path is an absolute path
+// (optionally with a query string) on the upstream host.
+func (u *Upstream) Fetch(ctx context.Context, method, path string, header http.Header) (*http.Response, error) {
+	if u.draining.Load() {
+		return nil, ErrUnavailable
+	}
+
+	base, breaker := u.pick()
+	if base == nil {
+		return nil, ErrUnavailable
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		breaker.Abandon()
+		return nil, err
+	}
+	target := base.ResolveReference(ref)
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+	if err != nil {
+		breaker.Abandon()
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	u.requests.Add(1)
+	u.inFlight.Add(1)
+	defer u.inFlight.Add(-1)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		u.recordError(breaker, err)
+		return nil, err
+	}
+
+	u.recordResponse(breaker, resp.StatusCode)
+	return resp, nil
+}
+
+// SetDraining stops (or resumes) sending new requests to the upstream.
+// In-flight requests are allowed to finish.
+func (u *Upstream) SetDraining(draining bool) {
+	u.draining.Store(draining)
+}
+
+// SetTargets replaces the set of targets requests are balanced over.
+// In-flight requests finish against their original target.
+func (u *Upstream) SetTargets(targets []*url.URL) {
+	u.balancer.set(targets)
+
+	// Forget the breakers of targets that went away
+	current := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		current[target.String()] = true
+	}
+	u.breakersMu.Lock()
+	for key := range u.breakers {
+		if !current[key] {
+			delete(u.breakers, key)
+		}
+	}
+	u.breakersMu.Unlock()
+}
+
+// Targets returns the current targets
+func (u *Upstream) Targets() []*url.URL {
+	return u.balancer.list()
+}
+
+// ResetBreaker closes the circuit breakers of every target
+func (u *Upstream) ResetBreaker() {
+	u.breakersMu.Lock()
+	defer u.breakersMu.Unlock()
+
+	for _, breaker := range u.breakers {
+		breaker.Reset()
+	}
+}
+
+// Stats returns the current upstream stats
+func (u *Upstream) Stats() UpstreamStats {
+	stats := UpstreamStats{
+		Name:     u.Name,
+		Targets:  make([]string, 0, len(u.Targets())),
+		Requests: u.requests.Load(),
+		Failures: u.failures.Load(),
+		InFlight: u.inFlight.Load(),
+		Draining: u.draining.Load(),
+	}
+
+	for _, target := range u.Targets() {
+		stats.Targets = append(stats.Targets, target.Redacted())
+		if breaker := u.existingBreaker(target); breaker != nil {
+			if stats.Breakers == nil {
+				stats.Breakers = make(map[string]BreakerStats)
+			}
+			stats.Breakers[target.Redacted()] = breaker.Stats()
+		}
+	}
+
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if u.lastError != "" {
+		lastErrAt := u.lastErrAt
+		stats.LastError = u.lastError
+		stats.LastErrorAt = &lastErrAt
+	}
+	return stats
+}
+
+// CheckHealth probes the upstream. Any response below 500 counts as healthy,
+// since most services don't expose a dedicated health route at their root.
+func (u *Upstream) CheckHealth(ctx context.Context) error {
+	target := u.balancer.pick()
+	if target == nil {
+		return errors.New("no targets")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
 	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("upstream returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// pick returns the next target whose breaker lets a request through, along
+// with that breaker (nil when breakers are disabled). The target is nil when
+// there are none or all of them are open.
+func (u *Upstream) pick() (*url.URL, *CircuitBreaker) {
+	for range u.balancer.list() {
+		target := u.balancer.pick()
+		if target == nil {
+			break
+		}
+		breaker := u.breakerFor(target)
+		if breaker.Allow() {
+			return target, breaker
+		}
+	}
+	return nil, nil
+}
+
+func (u *Upstream) breakerFor(target *url.URL) *CircuitBreaker {
+	if u.breakerOptions.Threshold <= 0 {
+		return nil
+	}
+
+	u.breakersMu.Lock()
+	defer u.breakersMu.Unlock()
+
+	key := target.String()
+	breaker := u.breakers[key]
+	if breaker == nil {
+		breaker = NewCircuitBreaker(u.breakerOptions.Threshold, u.breakerOptions.Cooldown)
+		u.breakers[key] = breaker
+	}
+	return breaker
+}
+
+// existingBreaker is breakerFor without creating one, for read-only callers
+func (u *Upstream) existingBreaker(target *url.URL) *CircuitBreaker {
+	u.breakersMu.Lock()
+	defer u.breakersMu.Unlock()
+
+	return u.breakers[target.String()]
+}
+
+// recordResponse counts 5xx responses as failures. Only 502-504, which mean the
+// target itself is unreachable or overloaded, count against its breaker; other
+// errors are the application's and would trip it on a single bad endpoint.
+func (u *Upstream) recordResponse(breaker *CircuitBreaker, status int) {
+	if status >= 500 {
+		u.recordFailure(fmt.Sprintf("upstream returned %d", status))
+	}
+	if status >= http.StatusBadGateway && status <= http.StatusGatewayTimeout {
+		breaker.Failure()
+	} else {
+		breaker.Success()
+	}
+}
+
+// recordError counts a transport error against the target
+func (u *Upstream) recordError(breaker *CircuitBreaker, err error) {
+	// A client hanging up isn't the upstream's fault
+	if errors.Is(err, context.Canceled) {
+		breaker.Abandon()
+		return
+	}
+	u.recordFailure(err.Error())
+	breaker.Failure()
+}
+
+func (u *Upstream) recordFailure(reason string) {
+	u.failures.Add(1)
+
+	u.mu.Lock()
+	u.lastError = reason
+	u.lastErrAt = time.Now()
+	u.mu.Unlock()
 }
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// Route maps a path prefix on the gateway to an upstream
+type Route struct {
+	Name        string
+	Prefix      string // Empty for the fallback route
+	Upstream    *Upstream
+	StripPrefix bool
+}
+
+// RouteStats is a point-in-time view of a route
+type RouteStats struct {
+	Name        string `json:"name"`
+	Prefix      string `json:"prefix"`
+	Upstream    string `json:"upstream"`
+	StripPrefix bool   `json:"stripPrefix"`
+}
+
+// Handler returns the gin handler serving this route
+func (r *Route) Handler() gin.HandlerFunc {
+	stripPrefix := ""
+	if r.StripPrefix {
+		stripPrefix = r.Prefix
+	}
+	return r.Upstream.Handler(stripPrefix)
+}
+
+// Stats returns the route's current state
+func (r *Route) Stats() RouteStats {
+	return RouteStats{
+		Name:        r.Name,
+		Prefix:      r.Prefix,
+		Upstream:    r.Upstream.Name,
+		StripPrefix: r.StripPrefix,
+	}
+}
@@ -2,31 +2,133 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
 
+	"github.com/eshop/api-gateway-go/internal/admin"
+	"github.com/eshop/api-gateway-go/internal/aggregate"
+	"github.com/eshop/api-gateway-go/internal/apikey"
+	"github.com/eshop/api-gateway-go/internal/bot"
+	"github.com/eshop/api-gateway-go/internal/capture"
 	"github.com/eshop/api-gateway-go/internal/config"
+	"github.com/eshop/api-gateway-go/internal/discovery"
+	"github.com/eshop/api-gateway-go/internal/fault"
+	"github.com/eshop/api-gateway-go/internal/grpcproxy"
+	"github.com/eshop/api-gateway-go/internal/maintenance"
 	"github.com/eshop/api-gateway-go/internal/middleware"
+	"github.com/eshop/api-gateway-go/internal/mock"
+	"github.com/eshop/api-gateway-go/internal/openapi"
 	"github.com/eshop/api-gateway-go/internal/proxy"
+	"github.com/eshop/api-gateway-go/internal/tlsutil"
+	"github.com/eshop/api-gateway-go/internal/usage"
+	"github.com/eshop/api-gateway-go/pkg/signing"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 type Server struct {
-	router *gin.Engine
-	server *http.Server
-	cfg    *config.Config
+	router    *gin.Engine
+	server    *http.Server
+	cfg       *config.Config
+	apiKeys   *apikey.Store
+	routes    []*proxy.Route
+	upstreams []*proxy.Upstream
+	discovery []*discovery.Watcher
+	admin     *admin.Server
+	capture   *capture.Recorder
+	usage     *usage.Meter
+
+	// Lifecycle
+	ready atomic.Bool
+	conns *connTracker
+
+	// TLS
+	redirect      *http.Server
+	certReloaders []*tlsutil.CertReloader
+	watchCtx      context.Context
+	stopWatchers  context.CancelFunc
 }
 
-func NewServer(cfg *config.Config) *Server {
+func NewServer(cfg *config.Config) (*Server, error) {
 	// Set Gin to release mode in production
 	// gin.SetMode(gin.ReleaseMode)
 
 	router := gin.Default()
+	// Client IPs drive maintenance bypass, rate limits and bot scoring, so
+	// X-Forwarded-For is only believed from the configured proxies
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid GATEWAY_TRUSTED_PROXIES: %w", err)
+	}
+
+	s := &Server{
+		router: router,
+		cfg:    cfg,
+		conns:  newConnTracker(),
+	}
+	s.ready.Store(true)
+
+	// Probes are registered before any middleware so load balancers are never rate limited
+	router.GET("/livez", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "alive"})
+	})
+	router.GET("/readyz", func(c *gin.Context) {
+		if !s.ready.Load() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	})
+
+	apiKeys, err := apikey.NewStore(cfg.APIKeysFile, cfg.APIKeyDefaultRate, cfg.APIKeyDefaultBurst)
+	if err != nil {
+		return nil, err
+	}
+
+	rateLimiter := middleware.NewDefaultIPRateLimiter()
+
+	maint, err := maintenance.NewManager(cfg.MaintenanceFile, cfg.AccessTokenSecret, cfg.MaintenanceRetryAfter)
+	if err != nil {
+		return nil, err
+	}
 
 	// Apply Middleware
 	router.Use(middleware.CORS(cfg.CORSOrigins))
-	router.Use(middleware.RateLimitMiddleware())
+	router.Use(middleware.UserIdentity(cfg.AccessTokenSecret))
+	router.Use(bot.StripHeader())
+	router.Use(middleware.APIKeyAuth(apiKeys))
+	router.Use(middleware.RateLimitMiddleware(rateLimiter))
+
+	// TLS towards upstreams, with a client certificate for mTLS if configured
+	upstreamTLS, upstreamCert, err := tlsutil.UpstreamConfig(cfg.UpstreamClientCert, cfg.UpstreamClientKey, cfg.UpstreamCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// gRPC and gRPC-Web calls are routed by service/method instead of path prefix
+	grpcRoutes, err := grpcproxy.LoadRoutes(cfg.GRPCRoutesFile)
+	if err != nil {
+		return nil, err
+	}
+	// Upstreams verify the gateway's signature before trusting identity headers
+	var sign func(http.RoundTripper) http.RoundTripper
+	if cfg.SigningSecret != "" {
+		sign = signing.NewSigner(cfg.SigningSecret, nil).Transport
+		log.Printf("Signing requests to upstreams")
+	}
+
+	grpcProxy, err := grpcproxy.New(grpcRoutes, upstreamTLS, sign)
+	if err != nil {
+		return nil, err
+	}
+	router.Use(grpcProxy.Middleware())
 
 	// Health Check
 	router.GET("/gateway-health", func(c *gin.Context) {
@@ -35,80 +137,373 @@ func NewServer(cfg *config.Config) *Server {
 		})
 	})
 
+	transport := newUpstreamTransport(upstreamTLS)
+	if sign != nil {
+		transport = sign(transport)
+	}
+
+	upstreams, watchers, err := newUpstreams(cfg, transport)
+	if err != nil {
+		return nil, err
+	}
+	routes := newRoutes(upstreams)
+
+	// Optional contract validation against the upstreams' OpenAPI documents
+	validator, err := openapi.Load(cfg.OpenAPISpecsFile, cfg.OpenAPIResponseSampleRate, int64(cfg.OpenAPIMaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	// Per-seller usage metering and monthly quotas
+	meter, err := usage.NewMeter(cfg.UsageFile, cfg.QuotasFile, cfg.UsageRetentionDays, cfg.AccessTokenSecret)
+	if err != nil {
+		return nil, err
+	}
+	meter.RegisterReport(router)
+	s.usage = meter
+	metered := make(map[string]bool, len(cfg.MeteredRoutes))
+	for _, name := range cfg.MeteredRoutes {
+		metered[name] = true
+	}
+
+	// Bot scoring on storefront routes, flagged requests are tagged for upstreams
+	bots, err := bot.New(bot.Options{
+		Mode:          cfg.BotMode,
+		Routes:        cfg.BotRoutes,
+		Threshold:     cfg.BotThreshold,
+		AllowedAgents: cfg.BotAllowedAgents,
+		Secret:        cfg.BotSecret,
+	})
+	if err != nil {
+		return nil, err
+	}
+	bots.RegisterChallenge(router)
+
+	// Fault injection for resilience testing outside production, the header
+	// only when explicitly enabled
+	faults, err := fault.Load(cfg.FaultsFile, cfg.Production(), cfg.FaultHeaderEnabled)
+	if err != nil {
+		return nil, err
+	}
+
+	// Opt-in capture of sampled traffic for later replay
+	recorder, err := capture.New(cfg.CaptureDir, cfg.CaptureRoutes, cfg.CaptureSampleRate, cfg.CaptureRedactFields)
+	if err != nil {
+		return nil, err
+	}
+	s.capture = recorder
+
+	// Bot scoring, metering and faults, shared by the routes and the composite
+	// endpoints that call them
+	routeMiddleware := func(names []string) []gin.HandlerFunc {
+		handlers := []gin.HandlerFunc{bots.Middleware(names...)}
+		for _, name := range names {
+			if metered[name] {
+				handlers = append(handlers, meter.Middleware())
+				break
+			}
+		}
+		for _, name := range names {
+			handlers = append(handlers, faults.Middleware(name))
+		}
+		return handlers
+	}
+
+	// Composite endpoints fan out to several upstreams and merge the results
+	endpoints, err := aggregate.LoadEndpoints(cfg.AggregatesFile)
+	if err != nil {
+		return nil, err
+	}
+	policy := aggregate.Policy{Middleware: routeMiddleware, Blocks: maint.Blocks}
+	if err := aggregate.Register(router, endpoints, routes, policy); err != nil {
+		return nil, err
+	}
+
 	// Configure Routes
 	// Note: The original gateway uses express-http-proxy which forwards the path.
-	// We need to ensure the path is correctly preserved.
 	// Gin's wildcard param *path captures the rest of the path.
+	for _, route := range routes {
+		handlers := append([]gin.HandlerFunc{maint.Middleware(route.Name)}, routeMiddleware([]string{route.Name})...)
+		handlers = append(handlers, validator.Middleware(route), recorder.Middleware(route), route.Handler())
+		if route.Prefix == "" {
+			// Fallback to Auth Service (as per original gateway)
+			// We use NoRoute to handle everything else
+			router.NoRoute(handlers...)
+			continue
+		}
+		router.Any(route.Prefix+"/*path", handlers...)
+		router.Any(route.Prefix, handlers...)
+	}
 
-	// Auth Service
-	router.Any("/users/*path", proxy.NewReverseProxy(cfg.AuthServiceURL, "")) // No strip for users? Original: app.use('/', proxy(...auth...)). Wait.
-	// Original: app.use('/', proxy(...auth...)) matches everything.
-	// But usually specific routes come first.
-	// The original gateway didn't have specific /users route. It just fell through to /.
-	// So /users went to auth service.
-	// If we want to match original behavior, we shouldn't strip /users if it wasn't stripped before.
-	// But wait, app.use('/', ...) matches /users and strips /. So it sends /users.
-	// Here we match /users/*path. We should probably NOT strip /users if we want it to arrive as /users.
-	// But if we want to be safe, let's look at auth service.
-	// Let's assume for now we only strip the explicit service prefixes.
-
-	router.Any("/auth/*path", proxy.NewReverseProxy(cfg.AuthServiceURL, "")) // Don't strip /auth?
-	// If original was app.use('/', ...), then /auth/login -> /auth/login.
-	// So we don't strip.
-
-	// Product Service
-	router.Any("/products/*path", proxy.NewReverseProxy(cfg.ProductServiceURL, "/products"))
-	router.Any("/products", proxy.NewReverseProxy(cfg.ProductServiceURL, "/products"))
-
-	// Order Service
-	router.Any("/orders/*path", proxy.NewReverseProxy(cfg.OrderServiceURL, "/orders"))
-	router.Any("/orders", proxy.NewReverseProxy(cfg.OrderServiceURL, "/orders"))
-
-	// Admin Service
-	router.Any("/admin/*path", proxy.NewReverseProxy(cfg.AdminServiceURL, "/admin"))
-	router.Any("/admin", proxy.NewReverseProxy(cfg.AdminServiceURL, "/admin"))
-
-	// Chat Service
-	router.Any("/chats/*path", proxy.NewReverseProxy(cfg.ChatServiceURL, "/chats"))
-	router.Any("/chats", proxy.NewReverseProxy(cfg.ChatServiceURL, "/chats"))
-
-	// Logger Service
-	router.Any("/logs/*path", proxy.NewReverseProxy(cfg.LoggerServiceURL, "/logs"))
-	router.Any("/logs", proxy.NewReverseProxy(cfg.LoggerServiceURL, "/logs"))
-
-	// Recommendation Service
-	router.Any("/recommendation/*path", proxy.NewReverseProxy(cfg.RecommendationServiceURL, "/recommendation"))
-	router.Any("/recommendation", proxy.NewReverseProxy(cfg.RecommendationServiceURL, "/recommendation"))
-
-	// Seller Service
-	router.Any("/seller/*path", proxy.NewReverseProxy(cfg.SellerServiceURL, "/seller"))
-	router.Any("/seller", proxy.NewReverseProxy(cfg.SellerServiceURL, "/seller"))
-
-	// Fallback to Auth Service (as per original gateway)
-	// We use NoRoute to handle everything else
-	router.NoRoute(proxy.NewReverseProxy(cfg.AuthServiceURL, ""))
-
+	// h2c lets gRPC clients talk HTTP/2 to the gateway without TLS
 	server := &http.Server{
 		Addr:    ":" + cfg.Port,
-		Handler: router,
+		Handler: h2c.NewHandler(router, &http2.Server{}),
 	}
 
-	return &Server{
-		router: router,
-		server: server,
-		cfg:    cfg,
+	s.server = server
+	s.apiKeys = apiKeys
+	s.routes = routes
+	s.upstreams = upstreams
+	s.discovery = watchers
+
+	s.watchCtx, s.stopWatchers = context.WithCancel(context.Background())
+	if upstreamCert != nil {
+		s.certReloaders = append(s.certReloaders, upstreamCert)
+	}
+	if cfg.TLSEnabled() {
+		if err := s.enableTLS(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Control-plane API (disabled unless GATEWAY_ADMIN_TOKEN is set)
+	if cfg.AdminToken != "" {
+		s.admin = admin.NewServer(admin.Deps{
+			Config:      cfg,
+			Routes:      routes,
+			Upstreams:   upstreams,
+			RateLimiter: rateLimiter,
+			APIKeys:     apiKeys,
+			Maintenance: maint,
+			GRPC:        grpcProxy,
+			Usage:       meter,
+		})
+		s.admin.RegisterCache("discovery", s.refreshDiscovery)
+		s.admin.RegisterCache("bot-clients", func(context.Context) error {
+			bots.Purge()
+			return nil
+		})
+	}
+
+	return s, nil
+}
+
+// refreshDiscovery re-resolves every discovered upstream now rather than on
+// its next interval
+func (s *Server) refreshDiscovery(ctx context.Context) error {
+	var errs []error
+	for _, watcher := range s.discovery {
+		if err := watcher.Refresh(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", watcher.Upstream, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newUpstreams creates one upstream per backend service, along with watchers
+// for the ones whose targets come from service discovery
+func newUpstreams(cfg *config.Config, transport http.RoundTripper) ([]*proxy.Upstream, []*discovery.Watcher, error) {
+	targets := []struct{ name, url string }{
+		{"auth", cfg.AuthServiceURL},
+		{"product", cfg.ProductServiceURL},
+		{"order", cfg.OrderServiceURL},
+		{"admin", cfg.AdminServiceURL},
+		{"chat", cfg.ChatServiceURL},
+		{"logger", cfg.LoggerServiceURL},
+		{"recommendation", cfg.RecommendationServiceURL},
+		{"seller", cfg.SellerServiceURL},
+	}
+
+	breakerOptions := proxy.BreakerOptions{
+		Threshold: cfg.BreakerThreshold,
+		Cooldown:  time.Duration(cfg.BreakerCooldown) * time.Second,
+	}
+
+	upstreams := make([]*proxy.Upstream, 0, len(targets))
+	var watchers []*discovery.Watcher
+	for _, target := range targets {
+		upstreamURL, upstreamTransport := target.url, transport
+
+		// Serve from recorded fixtures when a mock directory exists for this upstream
+		mocked := false
+		if cfg.MocksDir != "" {
+			dir := filepath.Join(cfg.MocksDir, target.name)
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				fixtures, err := mock.Load(dir)
+				if err != nil {
+					return nil, nil, err
+				}
+				upstreamURL, upstreamTransport = "http://"+target.name+".mock", fixtures
+				mocked = true
+				log.Printf("Upstream %s is mocked by %d fixtures from %s", target.name, fixtures.Fixtures(), dir)
+			}
+		}
+
+		upstream, err := proxy.NewUpstream(target.name, upstreamURL, upstreamTransport, breakerOptions)
+		if err != nil {
+			return nil, nil, err
+		}
+		upstreams = append(upstreams, upstream)
+
+		if mocked {
+			continue
+		}
+
+		// A registry file entry wins over the URL, which may itself be dns:// or srv://
+		var provider discovery.Provider
+		if cfg.DiscoveryFile != "" && discovery.Registered(cfg.DiscoveryFile, target.name) {
+			provider = &discovery.File{Path: cfg.DiscoveryFile, Upstream: target.name}
+		} else if provider, err = discovery.ForURL(target.url); err != nil {
+			return nil, nil, err
+		}
+		if _, static := provider.(*discovery.Static); static {
+			continue
+		}
+
+		watcher := &discovery.Watcher{
+			Upstream: target.name,
+			Provider: provider,
+			Interval: time.Duration(cfg.DiscoveryInterval) * time.Second,
+			Apply:    upstream.SetTargets,
+		}
+		if err := watcher.Refresh(context.Background()); err != nil {
+			// Don't refuse to start, the watcher keeps trying and requests get a 503 meanwhile
+			log.Printf("Discovery: no targets yet for %s: %v", target.name, err)
+			upstream.SetTargets(nil)
+		}
+		watchers = append(watchers, watcher)
+	}
+	return upstreams, watchers, nil
+}
+
+func upstreamsByName(upstreams []*proxy.Upstream) map[string]*proxy.Upstream {
+	byName := make(map[string]*proxy.Upstream, len(upstreams))
+	for _, upstream := range upstreams {
+		byName[upstream.Name] = upstream
+	}
+	return byName
+}
+
+// newRoutes builds the gateway's route table
+func newRoutes(upstreams []*proxy.Upstream) []*proxy.Route {
+	byName := upstreamsByName(upstreams)
+
+	return []*proxy.Route{
+		// Auth Service
+		// The original gateway had app.use('/', proxy(...auth...)) which matches everything,
+		// so /users/* and /auth/* arrive at the auth service unstripped.
+		{Name: "users", Prefix: "/users", Upstream: byName["auth"]},
+		{Name: "auth", Prefix: "/auth", Upstream: byName["auth"]},
+
+		// The remaining services get their prefix stripped
+		{Name: "products", Prefix: "/products", Upstream: byName["product"], StripPrefix: true},
+		{Name: "orders", Prefix: "/orders", Upstream: byName["order"], StripPrefix: true},
+		{Name: "admin", Prefix: "/admin", Upstream: byName["admin"], StripPrefix: true},
+		{Name: "chats", Prefix: "/chats", Upstream: byName["chat"], StripPrefix: true},
+		{Name: "logs", Prefix: "/logs", Upstream: byName["logger"], StripPrefix: true},
+		{Name: "recommendation", Prefix: "/recommendation", Upstream: byName["recommendation"], StripPrefix: true},
+		{Name: "seller", Prefix: "/seller", Upstream: byName["seller"], StripPrefix: true},
+
+		// Everything else falls through to the auth service
+		{Name: "default", Upstream: byName["auth"]},
 	}
 }
 
 func (s *Server) Start() error {
-	log.Printf("Starting API Gateway on %s", s.server.Addr)
-	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if s.admin != nil {
+		go func() {
+			if err := s.admin.Start(); err != nil {
+				log.Printf("Admin API stopped: %v", err)
+			}
+		}()
+	}
+
+	// Pick up renewed certificates without a restart
+	for _, reloader := range s.certReloaders {
+		go reloader.Watch(s.watchCtx, time.Duration(s.cfg.TLSReloadInterval)*time.Second)
+	}
+
+	go s.usage.Run(s.watchCtx, time.Minute)
+
+	// Follow scaled replicas as discovery reports them
+	for _, watcher := range s.discovery {
+		go watcher.Run(s.watchCtx)
+	}
+
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+	// Track connections ourselves so hijacked WebSockets can be drained too
+	listener = &trackingListener{Listener: listener, tracker: s.conns}
+
+	if s.server.TLSConfig != nil {
+		s.startRedirect()
+		log.Printf("Starting API Gateway with TLS on %s", s.server.Addr)
+		// Certificates come from TLSConfig.GetCertificate
+		err = s.server.ServeTLS(listener, "", "")
+	} else {
+		log.Printf("Starting API Gateway on %s", s.server.Addr)
+		err = s.server.Serve(listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("failed to start server: %w", err)
 	}
 	return nil
 }
 
+// BeginShutdown fails the readiness probe so load balancers stop sending new traffic
+func (s *Server) BeginShutdown() {
+	s.ready.Store(false)
+	log.Println("Readiness probe now failing, waiting for load balancers to notice...")
+}
+
+// Shutdown stops accepting connections and drains the open ones, including
+// WebSockets. Connections still open when ctx expires are closed forcibly.
 func (s *Server) Shutdown(ctx context.Context) error {
 	log.Println("Shutting down API Gateway...")
-	return s.server.Shutdown(ctx)
+	s.ready.Store(false)
+	s.stopWatchers()
+
+	// Report drain progress while we wait
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				log.Printf("Draining: %d connections remaining", s.conns.Count())
+			}
+		}
+	}()
+
+	if s.redirect != nil {
+		if err := s.redirect.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down HTTP redirect listener: %v", err)
+		}
+	}
+	if s.admin != nil {
+		if err := s.admin.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down admin API: %v", err)
+		}
+	}
+
+	// Flush captured traffic and usage once no more requests can arrive
+	defer s.capture.Close()
+	defer func() {
+		if err := s.usage.Flush(); err != nil {
+			log.Printf("Error flushing usage: %v", err)
+		}
+	}()
+
+	// Waits for in-flight HTTP requests, but not for hijacked connections
+	err := s.server.Shutdown(ctx)
+
+	for s.conns.Count() > 0 {
+		select {
+		case <-ctx.Done():
+			closed := s.conns.CloseAll()
+			log.Printf("Drain timeout reached, force closed %d connections", closed)
+			return err
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	log.Println("All connections drained")
+	return err
 }
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/eshop/api-gateway-go/internal/tlsutil"
+)
+
+// enableTLS switches the public listener to HTTPS with a hot-reloaded certificate,
+// and optionally adds a plain HTTP listener that redirects to it
+func (s *Server) enableTLS() error {
+	reloader, err := tlsutil.NewCertReloader(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, err := tlsutil.ServerConfig(reloader, s.cfg.TLSMinVersion, s.cfg.TLSCipherSuites)
+	if err != nil {
+		return err
+	}
+
+	s.server.TLSConfig = tlsConfig
+	s.certReloaders = append(s.certReloaders, reloader)
+
+	if s.cfg.TLSRedirectPort != "" {
+		s.redirect = &http.Server{
+			Addr:    ":" + s.cfg.TLSRedirectPort,
+			Handler: httpsRedirect(s.cfg.Port),
+		}
+	}
+	return nil
+}
+
+// newUpstreamTransport clones the default transport with the upstream TLS settings
+func newUpstreamTransport(tlsConfig *tls.Config) http.RoundTripper {
+	if tlsConfig == nil {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport
+}
+
+// httpsRedirect permanently redirects every request to the HTTPS listener
+func httpsRedirect(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		// 308 keeps the method and body, unlike 301
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
+
+func (s *Server) startRedirect() {
+	if s.redirect == nil {
+		return
+	}
+	go func() {
+		log.Printf("Redirecting HTTP on %s to HTTPS", s.redirect.Addr)
+		if err := s.redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP redirect listener stopped: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,209 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CertReloader serves a certificate/key pair from disk and picks up changes
+// (e.g. cert-manager or certbot renewals) without a restart
+type CertReloader struct {
+	certPath string
+	keyPath  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	certTime time.Time
+	keyTime  time.Time
+}
+
+// NewCertReloader loads the pair once and fails if it is invalid
+func NewCertReloader(certPath, keyPath string) (*CertReloader, error) {
+	r := &CertReloader{certPath: certPath, keyPath: keyPath}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Watch polls the files and reloads when either modification time changes.
+// A pair that fails to load is logged and the previous certificate kept.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			certTime, keyTime, err := r.modTimes()
+			if err != nil {
+				log.Printf("TLS: failed to stat certificate files: %v", err)
+				continue
+			}
+
+			r.mu.RLock()
+			changed := !certTime.Equal(r.certTime) || !keyTime.Equal(r.keyTime)
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err := r.reload(); err != nil {
+				log.Printf("TLS: keeping previous certificate, reload failed: %v", err)
+				continue
+			}
+			log.Printf("TLS: reloaded certificate from %s", r.certPath)
+		}
+	}
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// GetClientCertificate is used as tls.Config.GetClientCertificate
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func (r *CertReloader) reload() error {
+	certTime, keyTime, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate pair: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.certTime = certTime
+	r.keyTime = keyTime
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *CertReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(r.certPath)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(r.keyPath)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// ServerConfig builds the listener's TLS config
+func ServerConfig(reloader *CertReloader, minVersion string, cipherSuites []string) (*tls.Config, error) {
+	version, err := ParseVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := ParseCipherSuites(cipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:     version,
+		CipherSuites:   suites,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
+
+// UpstreamConfig builds the TLS config used towards upstreams. It presents a
+// client certificate (mTLS) when certPath/keyPath are set and trusts caPath in
+// addition to the system roots. Returns nil when nothing is configured.
+func UpstreamConfig(certPath, keyPath, caPath string) (*tls.Config, *CertReloader, error) {
+	if certPath == "" && keyPath == "" && caPath == "" {
+		return nil, nil, nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	var reloader *CertReloader
+	if certPath != "" || keyPath != "" {
+		if certPath == "" || keyPath == "" {
+			return nil, nil, fmt.Errorf("upstream client certificate needs both a cert and a key")
+		}
+		var err error
+		reloader, err = NewCertReloader(certPath, keyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.GetClientCertificate = reloader.GetClientCertificate
+	}
+
+	if caPath != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read upstream CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, nil, fmt.Errorf("no certificates found in upstream CA bundle %s", caPath)
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, reloader, nil
+}
+
+// ParseVersion accepts "1.0" through "1.3"
+func ParseVersion(version string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(version), "tls") {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q", version)
+}
+
+// ParseCipherSuites maps IANA names (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) to IDs.
+// An empty list keeps Go's defaults. TLS 1.3 suites are not configurable in Go.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,86 @@
+package usage
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/eshop/api-gateway-go/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterReport mounts the usage report for the admin-ui. Admins can read every
+// seller's usage, sellers only their own.
+func (m *Meter) RegisterReport(router gin.IRoutes) {
+	router.GET("/gateway/usage", m.authorize(false), m.ListHandler())
+	router.GET("/gateway/usage/:sellerId", m.authorize(true), m.SellerHandler())
+}
+
+func (m *Meter) authorize(allowSelf bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, err := auth.Identify(c.Request, m.tokenSecret)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+			return
+		}
+		if identity.Role == "admin" || (allowSelf && identity.Role == "seller" && identity.ID == c.Param("sellerId")) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Forbidden"})
+	}
+}
+
+// ListHandler lists every seller's plan and month-to-date usage
+func (m *Meter) ListHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sellers := m.Sellers()
+		usage := make([]SellerUsage, 0, len(sellers))
+		for _, sellerID := range sellers {
+			usage = append(usage, m.Summary(sellerID))
+		}
+		c.JSON(http.StatusOK, gin.H{"sellers": usage})
+	}
+}
+
+// SellerHandler reports one seller's usage. Query parameters: from and to
+// (RFC 3339 or YYYY-MM-DD, defaulting to this month) and granularity
+// (hour, day or month, defaulting to day).
+func (m *Meter) SellerHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now().UTC()
+		from, err := parseTime(c.Query("from"), startOfMonth(now))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid from: " + err.Error()})
+			return
+		}
+		to, err := parseTime(c.Query("to"), now)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid to: " + err.Error()})
+			return
+		}
+		granularity := c.DefaultQuery("granularity", "day")
+		if granularity != "hour" && granularity != "day" && granularity != "month" {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "granularity must be hour, day or month"})
+			return
+		}
+
+		sellerID := c.Param("sellerId")
+		c.JSON(http.StatusOK, gin.H{
+			"usage":       m.Summary(sellerID),
+			"from":        from,
+			"to":          to,
+			"granularity": granularity,
+			"buckets":     m.Report(sellerID, from, to, granularity),
+		})
+	}
+}
+
+func parseTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
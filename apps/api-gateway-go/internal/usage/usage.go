@@ -0,0 +1,421 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eshop/api-gateway-go/internal/auth"
+	"github.com/eshop/api-gateway-go/internal/fileutil"
+	"github.com/gin-gonic/gin"
+)
+
+var ErrUnknownTier = errors.New("unknown tier")
+
+// Counts is metered traffic
+type Counts struct {
+	Requests int64 `json:"requests"`
+	BytesIn  int64 `json:"bytesIn"`
+	BytesOut int64 `json:"bytesOut"`
+}
+
+func (c *Counts) add(other Counts) {
+	c.Requests += other.Requests
+	c.BytesIn += other.BytesIn
+	c.BytesOut += other.BytesOut
+}
+
+// Tier is a marketplace plan's monthly allowance. Zero means unlimited.
+type Tier struct {
+	MonthlyRequests int64 `json:"monthlyRequests"`
+	MonthlyBytes    int64 `json:"monthlyBytes"` // Request plus response bytes
+}
+
+// Plans assigns sellers to tiers
+type Plans struct {
+	Tiers       map[string]Tier   `json:"tiers"`
+	DefaultTier string            `json:"defaultTier"` // Empty means unlimited
+	Sellers     map[string]string `json:"sellers"`     // Seller ID -> tier name
+}
+
+// SellerUsage is a seller's plan and what they have used this month
+type SellerUsage struct {
+	SellerID    string `json:"sellerId"`
+	Tier        string `json:"tier,omitempty"`
+	Quota       *Tier  `json:"quota,omitempty"`
+	MonthToDate Counts `json:"monthToDate"`
+}
+
+// Bucket is usage over one period of a report
+type Bucket struct {
+	Start time.Time `json:"start"`
+	Counts
+}
+
+// Meter counts requests and bytes per seller in hourly buckets and enforces
+// monthly quotas. Buckets are kept in memory and flushed to usagePath; tier
+// assignments are written back to plansPath.
+type Meter struct {
+	mu      sync.Mutex
+	buckets map[string]map[int64]*Counts // Seller ID -> hour (unix seconds) -> counts
+	month   time.Time                    // Start of the month monthly refers to
+	monthly map[string]*Counts           // Seller ID -> totals for the current month
+	plans   Plans
+	dirty   bool
+
+	usagePath   string
+	plansPath   string
+	retention   time.Duration
+	tokenSecret string
+}
+
+// NewMeter loads previous usage from usagePath and plans from plansPath, either may be empty
+func NewMeter(usagePath, plansPath string, retentionDays int, tokenSecret string) (*Meter, error) {
+	m := &Meter{
+		buckets:     make(map[string]map[int64]*Counts),
+		monthly:     make(map[string]*Counts),
+		plans:       Plans{Tiers: make(map[string]Tier), Sellers: make(map[string]string)},
+		usagePath:   usagePath,
+		plansPath:   plansPath,
+		retention:   time.Duration(retentionDays) * 24 * time.Hour,
+		tokenSecret: tokenSecret,
+	}
+
+	if plansPath != "" {
+		data, err := os.ReadFile(plansPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read quotas file: %w", err)
+		}
+		if err := json.Unmarshal(data, &m.plans); err != nil {
+			return nil, fmt.Errorf("failed to parse quotas file: %w", err)
+		}
+		if m.plans.Tiers == nil {
+			m.plans.Tiers = make(map[string]Tier)
+		}
+		if m.plans.Sellers == nil {
+			m.plans.Sellers = make(map[string]string)
+		}
+		if _, ok := m.plans.Tiers[m.plans.DefaultTier]; m.plans.DefaultTier != "" && !ok {
+			return nil, fmt.Errorf("default tier %q is not defined", m.plans.DefaultTier)
+		}
+	}
+
+	if usagePath != "" {
+		data, err := os.ReadFile(usagePath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read usage file: %w", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &m.buckets); err != nil {
+				return nil, fmt.Errorf("failed to parse usage file: %w", err)
+			}
+		}
+	}
+
+	m.rollMonthLocked(time.Now())
+	return m, nil
+}
+
+// Middleware meters requests made by sellers and answers 429 once their monthly quota is used up
+func (m *Meter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sellerID := m.sellerID(c)
+		if sellerID == "" {
+			c.Next()
+			return
+		}
+
+		now := time.Now()
+		tierName, tier, used := m.check(sellerID, now)
+		if tier != nil {
+			c.Header("X-Quota-Tier", tierName)
+			if tier.MonthlyRequests > 0 {
+				c.Header("X-Quota-Requests-Remaining", strconv.FormatInt(max(tier.MonthlyRequests-used.Requests, 0), 10))
+			}
+
+			if (tier.MonthlyRequests > 0 && used.Requests >= tier.MonthlyRequests) ||
+				(tier.MonthlyBytes > 0 && used.BytesIn+used.BytesOut >= tier.MonthlyBytes) {
+				nextMonth := startOfMonth(now).AddDate(0, 1, 0)
+				c.Header("Retry-After", strconv.Itoa(int(nextMonth.Sub(now).Seconds())+1))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+					"message": fmt.Sprintf("Monthly quota for the %s plan is used up", tierName),
+				})
+				return
+			}
+		}
+
+		c.Next()
+
+		var counts Counts
+		counts.Requests = 1
+		if c.Request.ContentLength > 0 {
+			counts.BytesIn = c.Request.ContentLength
+		}
+		if size := c.Writer.Size(); size > 0 {
+			counts.BytesOut = int64(size)
+		}
+		m.record(sellerID, counts, time.Now())
+	}
+}
+
+// sellerID identifies the tenant: the API key's seller, else a seller access token
+func (m *Meter) sellerID(c *gin.Context) string {
+	// Only APIKeyAuth sets this, it strips any value sent by the client
+	if id := c.GetHeader("X-Seller-Id"); id != "" {
+		return id
+	}
+	identity, err := auth.Identify(c.Request, m.tokenSecret)
+	if err == nil && identity.Role == "seller" {
+		return identity.ID
+	}
+	return ""
+}
+
+func (m *Meter) check(sellerID string, now time.Time) (string, *Tier, Counts) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollMonthLocked(now)
+	var used Counts
+	if monthly := m.monthly[sellerID]; monthly != nil {
+		used = *monthly
+	}
+	name, tier := m.tierLocked(sellerID)
+	return name, tier, used
+}
+
+func (m *Meter) record(sellerID string, counts Counts, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollMonthLocked(now)
+	hour := now.UTC().Truncate(time.Hour).Unix()
+	if m.buckets[sellerID] == nil {
+		m.buckets[sellerID] = make(map[int64]*Counts)
+	}
+	if m.buckets[sellerID][hour] == nil {
+		m.buckets[sellerID][hour] = &Counts{}
+	}
+	m.buckets[sellerID][hour].add(counts)
+
+	if m.monthly[sellerID] == nil {
+		m.monthly[sellerID] = &Counts{}
+	}
+	m.monthly[sellerID].add(counts)
+	m.dirty = true
+}
+
+// rollMonthLocked rebuilds the monthly totals when a new month starts
+func (m *Meter) rollMonthLocked(now time.Time) {
+	month := startOfMonth(now)
+	if month.Equal(m.month) {
+		return
+	}
+
+	m.month = month
+	m.monthly = make(map[string]*Counts)
+	for sellerID, hours := range m.buckets {
+		for hour, counts := range hours {
+			if hour < month.Unix() {
+				continue
+			}
+			if m.monthly[sellerID] == nil {
+				m.monthly[sellerID] = &Counts{}
+			}
+			m.monthly[sellerID].add(*counts)
+		}
+	}
+}
+
+func (m *Meter) tierLocked(sellerID string) (string, *Tier) {
+	name, ok := m.plans.Sellers[sellerID]
+	if !ok {
+		name = m.plans.DefaultTier
+	}
+	tier, ok := m.plans.Tiers[name]
+	if !ok {
+		return "", nil
+	}
+	return name, &tier
+}
+
+// Summary returns a seller's plan and month-to-date usage
+func (m *Meter) Summary(sellerID string) SellerUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollMonthLocked(time.Now())
+	usage := SellerUsage{SellerID: sellerID}
+	usage.Tier, usage.Quota = m.tierLocked(sellerID)
+	if monthly := m.monthly[sellerID]; monthly != nil {
+		usage.MonthToDate = *monthly
+	}
+	return usage
+}
+
+// Sellers returns every seller with recorded usage or an assigned tier
+func (m *Meter) Sellers() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for sellerID := range m.buckets {
+		seen[sellerID] = true
+	}
+	for sellerID := range m.plans.Sellers {
+		seen[sellerID] = true
+	}
+
+	sellers := make([]string, 0, len(seen))
+	for sellerID := range seen {
+		sellers = append(sellers, sellerID)
+	}
+	sort.Strings(sellers)
+	return sellers
+}
+
+// Report sums a seller's usage between from and to into hour, day or month buckets
+func (m *Meter) Report(sellerID string, from, to time.Time, granularity string) []Bucket {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	totals := make(map[int64]*Counts)
+	for hour, counts := range m.buckets[sellerID] {
+		start := time.Unix(hour, 0).UTC()
+		if start.Before(from.Truncate(time.Hour)) || !start.Before(to) {
+			continue
+		}
+		key := periodStart(start, granularity).Unix()
+		if totals[key] == nil {
+			totals[key] = &Counts{}
+		}
+		totals[key].add(*counts)
+	}
+
+	buckets := make([]Bucket, 0, len(totals))
+	for start, counts := range totals {
+		buckets = append(buckets, Bucket{Start: time.Unix(start, 0).UTC(), Counts: *counts})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+	return buckets
+}
+
+// Plans returns a copy of the tiers and seller assignments
+func (m *Meter) Plans() Plans {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	plans := Plans{
+		Tiers:       make(map[string]Tier, len(m.plans.Tiers)),
+		DefaultTier: m.plans.DefaultTier,
+		Sellers:     make(map[string]string, len(m.plans.Sellers)),
+	}
+	for name, tier := range m.plans.Tiers {
+		plans.Tiers[name] = tier
+	}
+	for sellerID, tier := range m.plans.Sellers {
+		plans.Sellers[sellerID] = tier
+	}
+	return plans
+}
+
+// SetSellerTier moves a seller to a tier. An empty tier returns them to the default.
+func (m *Meter) SetSellerTier(sellerID, tier string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if tier == "" {
+		delete(m.plans.Sellers, sellerID)
+	} else {
+		if _, ok := m.plans.Tiers[tier]; !ok {
+			return ErrUnknownTier
+		}
+		m.plans.Sellers[sellerID] = tier
+	}
+
+	if m.plansPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(m.plans, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fileutil.WriteAtomic(m.plansPath, data); err != nil {
+		return fmt.Errorf("failed to save quotas: %w", err)
+	}
+	return nil
+}
+
+// Run flushes usage to disk on every interval until ctx is done
+func (m *Meter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Flush(); err != nil {
+				log.Printf("Usage: %v", err)
+			}
+		}
+	}
+}
+
+// Flush drops buckets past retention and writes usage to disk if it changed
+func (m *Meter) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.usagePath == "" || !m.dirty {
+		return nil
+	}
+
+	if m.retention > 0 {
+		cutoff := time.Now().Add(-m.retention).Unix()
+		for sellerID, hours := range m.buckets {
+			for hour := range hours {
+				if hour < cutoff {
+					delete(hours, hour)
+				}
+			}
+			if len(hours) == 0 {
+				delete(m.buckets, sellerID)
+			}
+		}
+	}
+
+	data, err := json.Marshal(m.buckets)
+	if err != nil {
+		return err
+	}
+	if err := fileutil.WriteAtomic(m.usagePath, data); err != nil {
+		return fmt.Errorf("failed to save usage: %w", err)
+	}
+	m.dirty = false
+	return nil
+}
+
+func startOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func periodStart(t time.Time, granularity string) time.Time {
+	switch granularity {
+	case "hour":
+		return t.Truncate(time.Hour)
+	case "month":
+		return startOfMonth(t)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,34 @@
+// Command dlq-replay moves dead-lettered analytics events back into their
+// original topic once the cause has been fixed:
+//
+//	go run ./cmd/dlq-replay -dry-run
+//	go run ./cmd/dlq-replay -limit 100
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/eshop/kafka-service-go/internal/config"
+	"github.com/eshop/kafka-service-go/internal/kafka"
+)
+
+func main() {
+	limit := flag.Int("limit", 0, "stop after this many messages (0 for all)")
+	idle := flag.Duration("idle", 10*time.Second, "stop once the DLQ has been empty for this long")
+	dryRun := flag.Bool("dry-run", false, "print the messages without replaying them")
+	flag.Parse()
+
+	cfg := config.Load()
+
+	replayed, err := kafka.ReplayDLQ(cfg, kafka.ReplayOptions{Limit: *limit, Idle: *idle, DryRun: *dryRun})
+	if err != nil {
+		log.Fatalf("Replay stopped after %d messages: %v", replayed, err)
+	}
+	if *dryRun {
+		log.Printf("%d messages would be replayed from %s", replayed, cfg.KafkaDLQTopic)
+		return
+	}
+	log.Printf("Replayed %d messages from %s", replayed, cfg.KafkaDLQTopic)
+}
@@ -10,7 +10,9 @@ import (
 
 	"github.com/eshop/kafka-service-go/internal/config"
 	"github.com/eshop/kafka-service-go/internal/db"
+	"github.com/eshop/kafka-service-go/internal/dedup"
 	"github.com/eshop/kafka-service-go/internal/kafka"
+	"github.com/eshop/kafka-service-go/internal/metrics"
 	"github.com/eshop/kafka-service-go/internal/services"
 )
 
@@ -31,15 +33,44 @@ func main() {
 		}
 	}()
 
+	// Idempotent writes rely on the unique indexes
+	indexCtx, cancelIndexes := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := mongoDB.EnsureIndexes(indexCtx); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+	cancelIndexes()
+
 	// Initialize Analytics Service
 	analyticsService := services.NewAnalyticsService(mongoDB)
+	funnels := services.NewFunnels(mongoDB, cfg.FunnelInterval, cfg.FunnelWindows, cfg.FunnelSessionGap)
+	productCounters := services.NewProductCounters(mongoDB, services.ProductCounterOptions{
+		Interval:        cfg.ProductFlushInterval,
+		MaxEvents:       cfg.ProductFlushSize,
+		HourlyRetention: cfg.HourlyRetention,
+		FunnelRetention: funnels.Retention(),
+	})
+
+	// Processed events are remembered to drop duplicates
+	processed := dedup.NewStore(mongoDB.ProcessedEvents(), cfg.DedupTTL, cfg.DedupCacheSize)
 
 	// Initialize Kafka Consumer
-	consumer, err := kafka.NewConsumer(cfg, analyticsService)
+	consumer, err := kafka.NewConsumer(cfg, analyticsService, productCounters, processed)
 	if err != nil {
 		log.Fatalf("Failed to create Kafka consumer: %v", err)
 	}
 
+	metrics.Serve(cfg.Port)
+
+	// Roll hourly product buckets up into daily ones
+	if cfg.HourlyRetention <= cfg.RollupLookback+24*time.Hour {
+		log.Printf("HOURLY_RETENTION %s should exceed ROLLUP_LOOKBACK %s by more than a day, or rollups recompute days from partial data", cfg.HourlyRetention, cfg.RollupLookback)
+	}
+	rollup := services.NewRollup(mongoDB, cfg.RollupInterval, cfg.RollupLookback)
+	rollup.Start()
+
+	// Compute conversion funnels from the recorded funnel events
+	funnels.Start()
+
 	// Start Consumer
 	if err := consumer.Start(); err != nil {
 		log.Fatalf("Failed to start Kafka consumer: %v", err)
@@ -53,6 +84,8 @@ func main() {
 	log.Println("Shutting down Kafka Service...")
 
 	consumer.Close()
+	rollup.Close()
+	funnels.Close()
 
 	// Give some time for cleanup
 	time.Sleep(1 * time.Second)
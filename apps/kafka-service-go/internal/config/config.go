@@ -3,6 +3,9 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -14,6 +17,44 @@ type Config struct {
 	KafkaTopic     string
 	KafkaGroupID   string
 	DatabaseURL    string
+
+	// Failed events go through delayed retry topics, then the dead-letter topic
+	KafkaRetryDelays []time.Duration // One retry topic per delay, e.g. users-events.retry.1m
+	KafkaDLQTopic    string
+
+	// Events are processed by a pool of workers, in order per user
+	KafkaWorkers     int
+	KafkaMaxInFlight int // Partitions are paused once this many events are queued
+
+	// productAnalytics increments are coalesced per product and flushed every
+	// interval or once this many events are queued
+	ProductFlushInterval time.Duration
+	ProductFlushSize     int
+
+	// Hourly product buckets are kept for HourlyRetention and rolled up into
+	// daily buckets every RollupInterval, recomputing the last RollupLookback
+	HourlyRetention time.Duration
+	RollupInterval  time.Duration
+	RollupLookback  time.Duration
+
+	// Conversion funnels are recomputed every FunnelInterval for each sliding
+	// window, splitting a user's activity into sessions at gaps longer than
+	// FunnelSessionGap
+	FunnelWindows    []time.Duration
+	FunnelSessionGap time.Duration
+	FunnelInterval   time.Duration
+
+	// Duplicate events are dropped by eventId, or for v0 events without one by
+	// a hash of the event within DedupWindow
+	DedupTTL       time.Duration // How long processed keys are remembered
+	DedupWindow    time.Duration
+	DedupCacheSize int // Keys kept in memory in front of Mongo
+
+	// Events whose botScore reaches this, as tagged by the gateway, are
+	// dropped before they reach analytics
+	BotScoreThreshold int
+
+	Port string // Serves /metrics and /health
 }
 
 func Load() *Config {
@@ -22,13 +63,46 @@ func Load() *Config {
 		log.Println("No .env file found, relying on environment variables")
 	}
 
+	topic := getEnv("KAFKA_TOPIC", "users-events")
+
+	defaultFunnelWindows := []time.Duration{24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour}
+	funnelWindows := getEnvDurations("FUNNEL_WINDOWS", defaultFunnelWindows)
+	if len(funnelWindows) == 0 {
+		funnelWindows = defaultFunnelWindows
+	}
+
 	return &Config{
 		KafkaBrokerURL: getEnv("KAFKA_BROKER_URL", "localhost:9092"),
 		KafkaAPIKey:    getEnv("KAFKA_API_KEY", ""),
 		KafkaAPISecret: getEnv("KAFKA_API_SECRET", ""),
-		KafkaTopic:     getEnv("KAFKA_TOPIC", "users-events"),
+		KafkaTopic:     topic,
 		KafkaGroupID:   getEnv("KAFKA_GROUP_ID", "user-events-group-go"),
 		DatabaseURL:    getEnv("DATABASE_URL", "mongodb://localhost:27017/eshop"),
+
+		KafkaRetryDelays: getEnvDurations("KAFKA_RETRY_DELAYS", []time.Duration{time.Minute, 10 * time.Minute}),
+		KafkaDLQTopic:    getEnv("KAFKA_DLQ_TOPIC", topic+".dlq"),
+
+		KafkaWorkers:     getEnvInt("KAFKA_WORKERS", 16),
+		KafkaMaxInFlight: getEnvInt("KAFKA_MAX_IN_FLIGHT", 1000),
+
+		ProductFlushInterval: getEnvDuration("PRODUCT_FLUSH_INTERVAL", 500*time.Millisecond),
+		ProductFlushSize:     getEnvInt("PRODUCT_FLUSH_SIZE", 500),
+
+		HourlyRetention: getEnvDuration("HOURLY_RETENTION", 7*24*time.Hour),
+		RollupInterval:  getEnvDuration("ROLLUP_INTERVAL", 10*time.Minute),
+		RollupLookback:  getEnvDuration("ROLLUP_LOOKBACK", 48*time.Hour),
+
+		FunnelWindows:    funnelWindows,
+		FunnelSessionGap: getEnvDuration("FUNNEL_SESSION_GAP", 30*time.Minute),
+		FunnelInterval:   getEnvDuration("FUNNEL_INTERVAL", 15*time.Minute),
+
+		DedupTTL:       getEnvDuration("DEDUP_TTL", 24*time.Hour),
+		DedupWindow:    getEnvDuration("DEDUP_WINDOW", 10*time.Second),
+		DedupCacheSize: getEnvInt("DEDUP_CACHE_SIZE", 100000),
+
+		BotScoreThreshold: getEnvInt("BOT_SCORE_THRESHOLD", 70),
+
+		Port: getEnv("PORT", "6009"),
 	}
 }
 
@@ -38,3 +112,52 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvInt(key string, fallback int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid %s %q, using the default", key, value)
+		return fallback
+	}
+	return n
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using the default", key, value)
+		return fallback
+	}
+	return d
+}
+
+// getEnvDurations parses a comma separated list like "1m,10m". An invalid list
+// falls back to the default.
+func getEnvDurations(key string, fallback []time.Duration) []time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	var durations []time.Duration
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		d, err := time.ParseDuration(part)
+		if err != nil || d <= 0 {
+			log.Printf("Invalid %s %q, using the default", key, value)
+			return fallback
+		}
+		durations = append(durations, d)
+	}
+	return durations
+}
@@ -2,10 +2,12 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -65,3 +67,119 @@ func (m *MongoDB) UserAnalytics() *mongo.Collection {
 func (m *MongoDB) ProductAnalytics() *mongo.Collection {
 	return m.DB.Collection("productAnalytics")
 }
+
+func (m *MongoDB) ShopAnalytics() *mongo.Collection {
+	return m.DB.Collection("shopAnalytics")
+}
+
+func (m *MongoDB) UniqueShopVisitors() *mongo.Collection {
+	return m.DB.Collection("uniqueShopVisitors")
+}
+
+// ProcessedEvents holds dedup keys of processed events until expiresAt
+func (m *MongoDB) ProcessedEvents() *mongo.Collection {
+	return m.DB.Collection("processedEvents")
+}
+
+// ProductAnalyticsHourly holds per-product counters per hour, kept until
+// expiresAt
+func (m *MongoDB) ProductAnalyticsHourly() *mongo.Collection {
+	return m.DB.Collection("productAnalyticsHourly")
+}
+
+// ProductAnalyticsDaily holds per-product counters per day, rolled up from
+// the hourly buckets
+func (m *MongoDB) ProductAnalyticsDaily() *mongo.Collection {
+	return m.DB.Collection("productAnalyticsDaily")
+}
+
+// ShopAnalyticsDaily holds per-shop counters per day, rolled up from the
+// hourly product buckets
+func (m *MongoDB) ShopAnalyticsDaily() *mongo.Collection {
+	return m.DB.Collection("shopAnalyticsDaily")
+}
+
+// FunnelEvents holds the product actions funnels are computed from, kept
+// until expiresAt
+func (m *MongoDB) FunnelEvents() *mongo.Collection {
+	return m.DB.Collection("funnelEvents")
+}
+
+// ProductFunnels holds per-product and per-shop conversion funnels
+func (m *MongoDB) ProductFunnels() *mongo.Collection {
+	return m.DB.Collection("productFunnels")
+}
+
+// OrderItems and Orders are written by order-service; funnels read purchases
+// from them
+func (m *MongoDB) OrderItems() *mongo.Collection {
+	return m.DB.Collection("orderItems")
+}
+
+func (m *MongoDB) Orders() *mongo.Collection {
+	return m.DB.Collection("orders")
+}
+
+// EnsureIndexes creates the indexes the consumer relies on for idempotent
+// writes, rollups and retention. Names match the ones `prisma db push`
+// creates, so either may run first.
+func (m *MongoDB) EnsureIndexes(ctx context.Context) error {
+	indexes := []struct {
+		collection *mongo.Collection
+		model      mongo.IndexModel
+	}{
+		{m.UserAnalytics(), unique("userAnalytics_userId_key", "userId")},
+		{m.ProductAnalytics(), unique("productAnalytics_productId_key", "productId")},
+		{m.UniqueShopVisitors(), unique("uniqueShopVisitors_shopId_userId_key", "shopId", "userId")},
+		{m.ShopAnalytics(), unique("shopAnalytics_shopId_key", "shopId")},
+		{m.ProcessedEvents(), expiring("processedEvents_expiresAt_ttl")},
+		{m.ProductAnalyticsHourly(), unique("productAnalyticsHourly_productId_hour_key", "productId", "hour")},
+		{m.ProductAnalyticsHourly(), expiring("productAnalyticsHourly_expiresAt_ttl")},
+		{m.ProductAnalyticsHourly(), index("productAnalyticsHourly_day_idx", "day")},
+		{m.ProductAnalyticsDaily(), unique("productAnalyticsDaily_productId_day_key", "productId", "day")},
+		{m.ProductAnalyticsDaily(), index("productAnalyticsDaily_shopId_day_idx", "shopId", "day")},
+		{m.ShopAnalyticsDaily(), unique("shopAnalyticsDaily_shopId_day_key", "shopId", "day")},
+		{m.FunnelEvents(), expiring("funnelEvents_expiresAt_ttl")},
+		{m.FunnelEvents(), index("funnelEvents_at_idx", "at")},
+		{m.ProductFunnels(), unique("productFunnels_shopId_productId_window_key", "shopId", "productId", "window")},
+	}
+
+	for _, index := range indexes {
+		if _, err := index.collection.Indexes().CreateOne(ctx, index.model); err != nil {
+			return fmt.Errorf("failed to create %s index: %w", index.collection.Name(), err)
+		}
+	}
+
+	// productAnalytics.shopId used to be unique by mistake, which rejected
+	// every product of a shop but the first
+	if _, err := m.ProductAnalytics().Indexes().DropOne(ctx, "productAnalytics_shopId_key"); err != nil && !indexNotFound(err) {
+		return fmt.Errorf("failed to drop productAnalytics shopId index: %w", err)
+	}
+	return nil
+}
+
+func indexNotFound(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(27) // IndexNotFound
+}
+
+func index(name string, fields ...string) mongo.IndexModel {
+	keys := bson.D{}
+	for _, field := range fields {
+		keys = append(keys, bson.E{Key: field, Value: 1})
+	}
+	return mongo.IndexModel{Keys: keys, Options: options.Index().SetName(name)}
+}
+
+func unique(name string, fields ...string) mongo.IndexModel {
+	model := index(name, fields...)
+	model.Options.SetUnique(true)
+	return model
+}
+
+// expiring removes documents once their expiresAt has passed
+func expiring(name string) mongo.IndexModel {
+	model := index(name, "expiresAt")
+	model.Options.SetExpireAfterSeconds(0)
+	return model
+}
@@ -0,0 +1,131 @@
+// Package dedup remembers which events have been processed so duplicates from
+// producer retries, double clicks and Kafka redelivery are dropped. Recent
+// keys are kept in memory; Mongo holds them for the full TTL and across
+// restarts and consumers.
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eshop/kafka-service-go/internal/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Key identifies an event for deduplication: its eventId, which producers keep
+// across resends and reuse for a double click, or for v0 events without one a
+// hash of who did what to which product within window
+func Key(event services.Event, window time.Duration) string {
+	if event.EventID != "" {
+		return "id:" + event.EventID
+	}
+	bucket := event.Timestamp.Truncate(window).Unix()
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		event.UserID, event.Action, event.ProductID, event.ShopID, strconv.FormatInt(bucket, 10),
+	}, "|")))
+	return "h:" + hex.EncodeToString(sum[:16])
+}
+
+// Store records processed keys in a TTL-indexed collection fronted by a
+// bounded LRU cache
+type Store struct {
+	collection *mongo.Collection
+	ttl        time.Duration
+
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // Most recently used at the front
+	cached   map[string]*list.Element
+}
+
+func NewStore(collection *mongo.Collection, ttl time.Duration, capacity int) *Store {
+	return &Store{
+		collection: collection,
+		ttl:        ttl,
+		capacity:   capacity,
+		order:      list.New(),
+		cached:     make(map[string]*list.Element),
+	}
+}
+
+// Seen reports whether key was already processed
+func (s *Store) Seen(ctx context.Context, key string) (bool, error) {
+	if s.cacheHit(key) {
+		return true, nil
+	}
+
+	// Expired documents linger until Mongo's TTL monitor runs, ignore them
+	filter := bson.M{"_id": key, "expiresAt": bson.M{"$gt": time.Now()}}
+	err := s.collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s.remember(key)
+	return true, nil
+}
+
+// Mark records keys as processed
+func (s *Store) Mark(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	expiresAt := time.Now().Add(s.ttl)
+	models := make([]mongo.WriteModel, 0, len(keys))
+	marked := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		// Duplicates of an event acknowledged together share its key
+		if marked[key] {
+			continue
+		}
+		marked[key] = true
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": key}).
+			SetUpdate(bson.M{"$set": bson.M{"expiresAt": expiresAt}}).
+			SetUpsert(true))
+	}
+	if _, err := s.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		s.remember(key)
+	}
+	return nil
+}
+
+func (s *Store) cacheHit(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.cached[key]
+	if ok {
+		s.order.MoveToFront(element)
+	}
+	return ok
+}
+
+func (s *Store) remember(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.cached[key]; ok {
+		s.order.MoveToFront(element)
+		return
+	}
+	s.cached[key] = s.order.PushFront(key)
+	if s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.cached, oldest.Value.(string))
+	}
+}
@@ -0,0 +1,107 @@
+// Package events decodes and validates users-events messages. v1 messages are
+// wrapped in an envelope:
+//
+//	{"eventId": "…", "schemaVersion": 1, "timestamp": "2024-05-01T12:00:00Z",
+//	 "source": "user-ui", "data": {"userId": "…", "action": "product_view", …}}
+//
+// Bare payloads predating the envelope are v0 and are upcast to v1.
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eshop/kafka-service-go/internal/services"
+)
+
+// CurrentVersion is the schema version producers should send
+const CurrentVersion = 1
+
+// LegacySource is the source given to upcast v0 events
+const LegacySource = "legacy"
+
+var v1 = mustLoadSchema("users-events.v1.json")
+
+func mustLoadSchema(name string) *schema {
+	s, err := loadSchema(name)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Envelope is the v1 wrapper around an event
+type Envelope struct {
+	EventID       string          `json:"eventId"`
+	SchemaVersion int             `json:"schemaVersion"`
+	Timestamp     time.Time       `json:"timestamp"`
+	Source        string          `json:"source"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// Decode validates a message against its schema version and returns the
+// event. received stands in for the timestamp of v0 events, which have none.
+// Schema violations are returned as *ValidationError with every failing field.
+func Decode(raw []byte, received time.Time) (services.Event, error) {
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return services.Event{}, fmt.Errorf("malformed event: %w", err)
+	}
+	object, ok := value.(map[string]any)
+	if !ok {
+		return services.Event{}, errors.New("malformed event: not a JSON object")
+	}
+
+	var envelope Envelope
+	version, versioned := object["schemaVersion"]
+	switch {
+	case !versioned:
+		if err := validate(v1.Defs["data"], value, "", 0); err != nil {
+			return services.Event{}, err
+		}
+		envelope = upcastV0(raw, received)
+	case version == float64(1):
+		if err := validate(v1, value, "", 1); err != nil {
+			return services.Event{}, err
+		}
+		if err := json.Unmarshal(raw, &envelope); err != nil {
+			return services.Event{}, fmt.Errorf("malformed event: %w", err)
+		}
+	default:
+		return services.Event{}, fmt.Errorf("unsupported schemaVersion %v", version)
+	}
+
+	var event services.Event
+	decoder := json.NewDecoder(bytes.NewReader(envelope.Data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&event); err != nil {
+		return services.Event{}, fmt.Errorf("malformed event data: %w", err)
+	}
+	event.EventID = envelope.EventID
+	event.Source = envelope.Source
+	event.Timestamp = envelope.Timestamp
+	return event, nil
+}
+
+// upcastV0 wraps a bare v0 payload in a v1 envelope. v0 events carry no ID;
+// the consumer dedups them by a hash of their contents instead.
+func upcastV0(raw []byte, received time.Time) Envelope {
+	return Envelope{
+		SchemaVersion: CurrentVersion,
+		Timestamp:     received,
+		Source:        LegacySource,
+		Data:          raw,
+	}
+}
+
+func validate(s *schema, value any, field string, version int) error {
+	v := &validator{root: v1}
+	v.validate(s, value, field)
+	if len(v.errors) > 0 {
+		return &ValidationError{Version: version, Errors: v.errors}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package events
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// schema is the subset of JSON Schema the event definitions use: type,
+// required, properties, additionalProperties, enum, const, min/maxLength,
+// minimum/maximum, format date-time, $ref into $defs and allOf with
+// if/then/else. Keeping the .json file as the source of truth lets producers
+// in other languages validate against the same document.
+type schema struct {
+	Type                 string             `json:"type"`
+	Required             []string           `json:"required"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Enum                 []any              `json:"enum"`
+	Const                any                `json:"const"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	Format               string             `json:"format"`
+	Ref                  string             `json:"$ref"`
+	Defs                 map[string]*schema `json:"$defs"`
+	AllOf                []*schema          `json:"allOf"`
+	If                   *schema            `json:"if"`
+	Then                 *schema            `json:"then"`
+	Else                 *schema            `json:"else"`
+}
+
+// FieldError is one validation failure, e.g. {"data.productId", "is required"}
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every field that failed validation
+type ValidationError struct {
+	Version int
+	Errors  []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		parts[i] = fieldErr.Field + ": " + fieldErr.Message
+	}
+	return fmt.Sprintf("invalid v%d event: %s", e.Version, strings.Join(parts, "; "))
+}
+
+func loadSchema(name string) (*schema, error) {
+	data, err := schemaFiles.ReadFile("schemas/" + name)
+	if err != nil {
+		return nil, err
+	}
+	var s schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %w", name, err)
+	}
+	return &s, nil
+}
+
+// validator checks values against a schema, resolving refs against root
+type validator struct {
+	root   *schema
+	errors []FieldError
+}
+
+func (v *validator) fail(field, format string, args ...any) {
+	if field == "" {
+		field = "(root)"
+	}
+	v.errors = append(v.errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(s *schema, value any, field string) {
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/$defs/")
+		if ref := v.root.Defs[name]; ok && ref != nil {
+			v.validate(ref, value, field)
+		} else {
+			v.fail(field, "unresolvable schema reference %s", s.Ref)
+		}
+		return
+	}
+
+	if s.Type != "" && !hasType(value, s.Type) {
+		v.fail(field, "must be of type %s", s.Type)
+		return
+	}
+	if s.Const != nil && !reflect.DeepEqual(value, s.Const) {
+		v.fail(field, "must be %v", s.Const)
+	}
+	if s.Enum != nil && !containsValue(s.Enum, value) {
+		v.fail(field, "must be one of %v", s.Enum)
+	}
+
+	switch typed := value.(type) {
+	case string:
+		length := len([]rune(typed))
+		if s.MinLength != nil && length < *s.MinLength {
+			if *s.MinLength == 1 {
+				v.fail(field, "must not be empty")
+			} else {
+				v.fail(field, "must be at least %d characters", *s.MinLength)
+			}
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			v.fail(field, "must be at most %d characters", *s.MaxLength)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, typed); err != nil {
+				v.fail(field, "must be an RFC 3339 date-time")
+			}
+		}
+	case float64:
+		if s.Minimum != nil && typed < *s.Minimum {
+			v.fail(field, "must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && typed > *s.Maximum {
+			v.fail(field, "must be at most %v", *s.Maximum)
+		}
+	case map[string]any:
+		v.validateObject(s, typed, field)
+	}
+
+	for _, sub := range s.AllOf {
+		v.validate(sub, value, field)
+	}
+	if s.If != nil {
+		probe := &validator{root: v.root}
+		probe.validate(s.If, value, field)
+		if len(probe.errors) == 0 && s.Then != nil {
+			v.validate(s.Then, value, field)
+		} else if len(probe.errors) > 0 && s.Else != nil {
+			v.validate(s.Else, value, field)
+		}
+	}
+}
+
+func (v *validator) validateObject(s *schema, object map[string]any, field string) {
+	for _, name := range s.Required {
+		if _, ok := object[name]; !ok {
+			v.fail(join(field, name), "is required")
+		}
+	}
+
+	// Sorted so errors come out in a stable order
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if property, ok := s.Properties[name]; ok {
+			v.validate(property, object[name], join(field, name))
+		} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+			v.fail(join(field, name), "is not allowed")
+		}
+	}
+}
+
+func join(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func hasType(value any, want string) bool {
+	switch want {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "null":
+		return value == nil
+	}
+	return false
+}
+
+func containsValue(values []any, value any) bool {
+	for _, candidate := range values {
+		if reflect.DeepEqual(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/eshop/kafka-service-go/internal/dedup"
+	"github.com/eshop/kafka-service-go/internal/metrics"
+)
+
+// Most events marked processed in one write
+const ackBatchSize = 500
+
+// acker finishes persisted events: it marks their dedup keys in bulk, then
+// stores their offsets. Product counters are written asynchronously, so this
+// happens after the flush that persisted an event rather than on its worker.
+type acker struct {
+	processed *dedup.Store
+	offsets   *offsetTracker
+	queue     chan persisted
+	pending   sync.WaitGroup // Queued events not yet finished
+	stopped   chan struct{}
+}
+
+type persisted struct {
+	msg *kafka.Message
+	key string
+}
+
+func newAcker(processed *dedup.Store, offsets *offsetTracker, size int) *acker {
+	a := &acker{
+		processed: processed,
+		offsets:   offsets,
+		queue:     make(chan persisted, size),
+		stopped:   make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// ack queues msg, whose event has been persisted under key
+func (a *acker) ack(msg *kafka.Message, key string) {
+	a.pending.Add(1)
+	a.queue <- persisted{msg: msg, key: key}
+}
+
+func (a *acker) run() {
+	defer close(a.stopped)
+
+	for first := range a.queue {
+		batch := []persisted{first}
+	collect:
+		for len(batch) < ackBatchSize {
+			select {
+			case next, ok := <-a.queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, next)
+			default:
+				break collect
+			}
+		}
+		a.finish(batch)
+	}
+}
+
+func (a *acker) finish(batch []persisted) {
+	keys := make([]string, len(batch))
+	for i, event := range batch {
+		keys[i] = event.key
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// Losing the marks only weakens dedup of later producer resends; the
+	// per-document guards still catch redeliveries
+	if err := a.processed.Mark(ctx, keys...); err != nil {
+		log.Printf("Error marking %d events processed: %v", len(keys), err)
+	}
+
+	for _, event := range batch {
+		a.offsets.finish(event.msg)
+		a.pending.Done()
+	}
+	metrics.EventsProcessed.Add(int64(len(batch)))
+}
+
+// drain waits for every queued event to finish
+func (a *acker) drain() {
+	a.pending.Wait()
+}
+
+// stop finishes what is queued and stops
+func (a *acker) stop() {
+	close(a.queue)
+	<-a.stopped
+}
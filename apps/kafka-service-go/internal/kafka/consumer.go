@@ -2,57 +2,128 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"github.com/eshop/kafka-service-go/internal/config"
+	"github.com/eshop/kafka-service-go/internal/dedup"
+	"github.com/eshop/kafka-service-go/internal/events"
+	"github.com/eshop/kafka-service-go/internal/metrics"
 	"github.com/eshop/kafka-service-go/internal/services"
 )
 
+// How often stored offsets are committed. A crash replays at most this much,
+// which idempotent writes absorb.
+const commitInterval = 5 * time.Second
+
+// How long a rebalance waits for in-flight events before giving up on them.
+// They are left unacknowledged and redelivered to the partitions' next owner,
+// rather than holding up the group during a Mongo outage.
+const rebalanceTimeout = 30 * time.Second
+
+// Backoff while a failed event can't be handed to a retry topic either
+const (
+	retryInitialBackoff = time.Second
+	retryMaxBackoff     = 30 * time.Second
+)
+
 type Consumer struct {
-	consumer  *kafka.Consumer
-	analytics *services.AnalyticsService
-	topic     string
-	ctx       context.Context
-	cancel    context.CancelFunc
-}
-
-func NewConsumer(cfg *config.Config, analytics *services.AnalyticsService) (*Consumer, error) {
-	c, err := kafka.NewConsumer(&kafka.ConfigMap{
-		"bootstrap.servers": cfg.KafkaBrokerURL,
-		"group.id":          cfg.KafkaGroupID,
-		"auto.offset.reset": "earliest",
-		// SASL configuration if needed (based on logger-service)
-		"security.protocol": "SASL_SSL",
-		"sasl.mechanisms":   "PLAIN",
-		"sasl.username":     cfg.KafkaAPIKey,
-		"sasl.password":     cfg.KafkaAPISecret,
-	})
+	consumer   *kafka.Consumer
+	producer   *kafka.Producer
+	analytics  *services.AnalyticsService
+	products   *services.ProductCounters
+	processed  *dedup.Store
+	window     time.Duration // Dedup window, see dedup.Key
+	botScore   int           // Events scored at least this are dropped
+	topic      string
+	retries    []retryTopic
+	dlqTopic   string
+	ctx        context.Context
+	cancel     context.CancelFunc
+	done       chan struct{}
+	lastCommit time.Time
+
+	pool        *workerPool
+	offsets     *offsetTracker
+	acks        *acker
+	maxInFlight int
+	saturated   bool                          // Assigned partitions are paused until workers catch up
+	abandoned   atomic.Pointer[chan struct{}] // Closed when a rebalance gives up on in-flight events
+
+	// Retry partitions paused until their next message is due
+	paused map[string]pausedPartition
+}
+
+type pausedPartition struct {
+	partition kafka.TopicPartition
+	until     time.Time
+}
+
+func NewConsumer(cfg *config.Config, analytics *services.AnalyticsService, products *services.ProductCounters, processed *dedup.Store) (*Consumer, error) {
+	conf := clientConfig(cfg)
+	conf["group.id"] = cfg.KafkaGroupID
+	conf["auto.offset.reset"] = "earliest"
+	// At-least-once: an offset is stored only once its event is persisted or
+	// handed to a retry topic, and stored offsets are committed by the consumer
+	conf["enable.auto.commit"] = false
+	conf["enable.auto.offset.store"] = false
+
+	c, err := kafka.NewConsumer(&conf)
+	if err != nil {
+		return nil, err
+	}
 
+	producer, err := newProducer(cfg)
 	if err != nil {
+		c.Close()
 		return nil, err
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &Consumer{
-		consumer:  c,
-		analytics: analytics,
-		topic:     cfg.KafkaTopic,
-		ctx:       ctx,
-		cancel:    cancel,
-	}, nil
+	consumer := &Consumer{
+		consumer:   c,
+		producer:   producer,
+		analytics:  analytics,
+		products:   products,
+		processed:  processed,
+		window:     cfg.DedupWindow,
+		botScore:   cfg.BotScoreThreshold,
+		topic:      cfg.KafkaTopic,
+		retries:    retryTopics(cfg.KafkaTopic, cfg.KafkaRetryDelays),
+		dlqTopic:   cfg.KafkaDLQTopic,
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		lastCommit: time.Now(),
+		paused:     make(map[string]pausedPartition),
+
+		offsets:     newOffsetTracker(c),
+		maxInFlight: cfg.KafkaMaxInFlight,
+	}
+	abandoned := make(chan struct{})
+	consumer.abandoned.Store(&abandoned)
+	consumer.acks = newAcker(processed, consumer.offsets, cfg.KafkaMaxInFlight)
+	consumer.pool = newWorkerPool(cfg.KafkaWorkers, cfg.KafkaMaxInFlight, consumer.work)
+	return consumer, nil
 }
 
 func (c *Consumer) Start() error {
-	err := c.consumer.SubscribeTopics([]string{c.topic}, nil)
+	topics := []string{c.topic}
+	for _, retry := range c.retries {
+		topics = append(topics, retry.name)
+	}
+
+	err := c.consumer.SubscribeTopics(topics, c.rebalance)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Kafka consumer started, subscribed to topic: %s", c.topic)
+	log.Printf("Kafka consumer started with %d workers, subscribed to topics: %v (dead letters to %s)", len(c.pool.queues), topics, c.dlqTopic)
 
 	go c.consumeLoop()
 
@@ -60,11 +131,20 @@ func (c *Consumer) Start() error {
 }
 
 func (c *Consumer) consumeLoop() {
+	defer close(c.done)
+	defer c.pool.stop()
+
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
 		default:
+			if time.Since(c.lastCommit) >= commitInterval {
+				c.commit()
+			}
+			c.backpressure()
+			c.resumeDue()
+
 			msg, err := c.consumer.ReadMessage(100 * time.Millisecond)
 			if err != nil {
 				if err.(kafka.Error).Code() == kafka.ErrTimedOut {
@@ -74,51 +154,287 @@ func (c *Consumer) consumeLoop() {
 				continue
 			}
 
-			c.processMessage(msg)
+			// Fetched before its partition was paused for backpressure
+			if c.saturated {
+				c.holdBack(msg)
+				continue
+			}
+
+			// Retries wait out their delay without holding up other partitions
+			if due, ok := retryAt(msg); ok && time.Now().Before(due) {
+				c.pauseUntil(msg, due)
+				continue
+			}
+
+			c.offsets.start(msg)
+			c.pool.dispatch(msg)
 		}
 	}
 }
 
-func (c *Consumer) processMessage(msg *kafka.Message) {
-	var event services.Event
-	if err := json.Unmarshal(msg.Value, &event); err != nil {
-		log.Printf("Error unmarshalling message: %v", err)
+// work runs on a pool worker. The offset is stored once msg and everything
+// before it in its partition is persisted or handed off.
+func (c *Consumer) work(msg *kafka.Message) {
+	if c.ctx.Err() != nil {
+		// Shutting down; the event is redelivered on restart
+		return
+	}
+	select {
+	case <-*c.abandoned.Load():
+		// A rebalance gave up on it; the event is redelivered to the next owner
 		return
+	default:
 	}
+	c.handleMessage(msg)
+}
 
-	// Validate event action (logic from TS main.ts)
-	validActions := map[string]bool{
-		"add_to_wishlist":      true,
-		"add_to_cart":          true,
-		"product_view":         true,
-		"purchase":             true,
-		"remove_from_cart":     true,
-		"remove_from_wishlist": true,
-		"shop_visit":           true, // Mentioned in TS main.ts but logic was empty/commented out
+// backpressure pauses every assigned partition once maxInFlight events are
+// waiting for workers, and resumes them when the backlog has halved. The
+// consumer keeps polling meanwhile so it stays in the group.
+func (c *Consumer) backpressure() {
+	inFlight := c.pool.inFlight.Load()
+	switch {
+	case !c.saturated && inFlight >= int64(c.maxInFlight):
+		assignment, err := c.consumer.Assignment()
+		if err != nil {
+			log.Printf("Error getting assignment: %v", err)
+			return
+		}
+		if err := c.consumer.Pause(assignment); err != nil {
+			log.Printf("Error pausing %v: %v", assignment, err)
+			return
+		}
+		c.saturated = true
+		log.Printf("Workers saturated with %d events in flight, paused consumption", inFlight)
+	case c.saturated && inFlight <= int64(c.maxInFlight/2):
+		assignment, err := c.consumer.Assignment()
+		if err != nil {
+			log.Printf("Error getting assignment: %v", err)
+			return
+		}
+		// Retry partitions stay paused until their next message is due
+		var resume []kafka.TopicPartition
+		for _, partition := range assignment {
+			if _, ok := c.paused[partitionKey(partition)]; !ok {
+				resume = append(resume, partition)
+			}
+		}
+		if err := c.consumer.Resume(resume); err != nil {
+			log.Printf("Error resuming %v: %v", resume, err)
+			return
+		}
+		c.saturated = false
+		log.Println("Workers caught up, resumed consumption")
+	}
+}
+
+// holdBack pauses msg's partition and rewinds it so msg is read again once
+// consumption resumes
+func (c *Consumer) holdBack(msg *kafka.Message) {
+	partition := msg.TopicPartition
+	partition.Error = nil
+	if err := c.consumer.Pause([]kafka.TopicPartition{partition}); err != nil {
+		log.Printf("Error pausing %v: %v", partition, err)
+	}
+	if err := c.consumer.Seek(partition, 0); err != nil {
+		log.Printf("Error rewinding %v: %v", partition, err)
 	}
+}
 
-	if !validActions[event.Action] {
+// handleMessage processes msg, handing failures to the next retry topic or
+// the dead-letter topic. Only if that fails too does it back off and try again
+// in place, so nothing is acknowledged that wasn't persisted somewhere. It
+// gives up if the consumer is closed first.
+func (c *Consumer) handleMessage(msg *kafka.Message) {
+	backoff := retryInitialBackoff
+	for {
+		err := c.processMessage(msg)
+		if err == nil {
+			return
+		}
+
+		routeErr := c.route(msg, err)
+		if routeErr == nil {
+			c.offsets.finish(msg)
+			return
+		}
+		log.Printf("Error handing off failed event %v, retrying in %s: %v", msg.TopicPartition, backoff, routeErr)
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-*c.abandoned.Load():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, retryMaxBackoff)
+	}
+}
+
+// route sends a failed event to the next retry topic, or to the dead-letter
+// topic once retries are exhausted or the failure is permanent
+func (c *Consumer) route(msg *kafka.Message, cause error) error {
+	attempt := attempts(msg) + 1
+
+	var perm *permanentError
+	if !errors.As(cause, &perm) && attempt <= len(c.retries) {
+		retry := c.retries[attempt-1]
+		log.Printf("Error processing %v (attempt %d), retrying via %s: %v", msg.TopicPartition, attempt, retry.name, cause)
+		return produce(c.producer, failed(msg, retry.name, attempt, cause, time.Now().Add(retry.delay)))
+	}
+
+	log.Printf("Error processing %v (attempt %d), sending to %s: %v", msg.TopicPartition, attempt, c.dlqTopic, cause)
+	return produce(c.producer, failed(msg, c.dlqTopic, attempt, cause, time.Time{}))
+}
+
+// pauseUntil stops fetching msg's partition until due and rewinds it so msg
+// is read again then
+func (c *Consumer) pauseUntil(msg *kafka.Message, due time.Time) {
+	partition := msg.TopicPartition
+	partition.Error = nil
+	if err := c.consumer.Pause([]kafka.TopicPartition{partition}); err != nil {
+		log.Printf("Error pausing %v: %v", partition, err)
 		return
 	}
+	if err := c.consumer.Seek(partition, 0); err != nil {
+		log.Printf("Error rewinding %v: %v", partition, err)
+	}
+	c.paused[partitionKey(partition)] = pausedPartition{partition: partition, until: due}
+}
 
-	// TS main.ts: if (event.action === 'shop_visit') { // update shop analytics }
-	// But the implementation was empty comment. We'll skip it for now or add a placeholder.
-	if event.Action == "shop_visit" {
-		// Placeholder for shop analytics
+// resumeDue resumes paused partitions whose next message is now due. While
+// saturated they wait for backpressure to resume everything.
+func (c *Consumer) resumeDue() {
+	if c.saturated {
 		return
 	}
+	now := time.Now()
+	for key, paused := range c.paused {
+		if now.Before(paused.until) {
+			continue
+		}
+		if err := c.consumer.Resume([]kafka.TopicPartition{paused.partition}); err != nil {
+			log.Printf("Error resuming %v: %v", paused.partition, err)
+		}
+		delete(c.paused, key)
+	}
+}
+
+func partitionKey(partition kafka.TopicPartition) string {
+	return fmt.Sprintf("%s/%d", *partition.Topic, partition.Partition)
+}
+
+// commit commits the stored offsets
+func (c *Consumer) commit() {
+	c.lastCommit = time.Now()
+	if _, err := c.consumer.Commit(); err != nil && err.(kafka.Error).Code() != kafka.ErrNoOffset {
+		log.Printf("Error committing offsets: %v", err)
+	}
+}
+
+// rebalance finishes in-flight events and commits them before partitions move
+// to another consumer, so it doesn't reprocess them. After rebalanceTimeout it
+// stops waiting: workers drop what they hold and product flushes give up, and
+// those events are redelivered instead.
+func (c *Consumer) rebalance(_ *kafka.Consumer, event kafka.Event) error {
+	if revoked, ok := event.(kafka.RevokedPartitions); ok {
+		log.Printf("Partitions revoked: %v, draining %d in-flight events", revoked.Partitions, c.pool.inFlight.Load())
+
+		abandoned := *c.abandoned.Load()
+		fired := make(chan struct{})
+		timer := time.AfterFunc(rebalanceTimeout, func() {
+			defer close(fired)
+			log.Printf("Still draining after %s, giving up on %d in-flight events; they will be redelivered", rebalanceTimeout, c.pool.inFlight.Load())
+			close(abandoned)
+			c.products.Abandon()
+		})
+
+		c.pool.drain()
+		c.products.Flush()
+		c.acks.drain()
 
-	// Process user/product analytics
+		if !timer.Stop() {
+			<-fired
+			c.products.Resume()
+			next := make(chan struct{})
+			c.abandoned.Store(&next)
+		}
+		c.commit()
+		c.offsets.forget(revoked.Partitions)
+		// Reassigned partitions start out unpaused
+		for _, partition := range revoked.Partitions {
+			delete(c.paused, partitionKey(partition))
+		}
+	}
+	return nil
+}
+
+// processMessage persists one event and acknowledges it once it is, which
+// for product counters happens after a later flush. Malformed and invalid
+// events fail permanently; everything else returned is worth retrying.
+func (c *Consumer) processMessage(msg *kafka.Message) error {
+	event, err := events.Decode(msg.Value, msg.Timestamp)
+	if err != nil {
+		return permanent(err)
+	}
+	// The gateway flagged the request behind this event as automated, keep it
+	// out of views and other analytics
+	if event.BotScore >= c.botScore {
+		metrics.BotEventsDropped.Add(1)
+		c.offsets.finish(msg)
+		return nil
+	}
+	// The dedup key stays the same across redeliveries, retries, producer
+	// resends and double clicks, so the idempotent writes recognise all of
+	// them, including duplicates still in flight when Seen is checked
+	key := dedup.Key(event, c.window)
+	event.ID = key
+
+	// Not tied to c.ctx, so events already being written finish on shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	seen, err := c.processed.Seen(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to check for duplicate: %w", err)
+	}
+	if seen {
+		metrics.DuplicatesDropped.Add(1)
+		c.offsets.finish(msg)
+		return nil
+	}
+
+	// Shop visits only feed shop analytics
+	if event.Action == "shop_visit" {
+		if err := c.analytics.UpdateShopAnalytics(ctx, event); err != nil {
+			return err
+		}
+		c.acks.ack(msg, key)
+		return nil
+	}
+
+	// Process user analytics, product counters are batched
 	if err := c.analytics.UpdateUserAnalytics(ctx, event); err != nil {
-		log.Printf("Error processing event: %v", err)
+		return err
+	}
+	if event.ProductID == "" {
+		c.acks.ack(msg, key)
+		return nil
 	}
+	c.products.Add(event, func() { c.acks.ack(msg, key) })
+	return nil
 }
 
+// Close stops consuming, waits for in-flight events, commits what was
+// processed and leaves the group
 func (c *Consumer) Close() {
 	c.cancel()
+	// Before waiting for the workers, which may be blocked on a full batch
+	c.products.Close()
+	<-c.done
+	c.acks.stop()
+	c.commit()
 	c.consumer.Close()
+	c.producer.Close()
 	log.Println("Kafka consumer closed")
 }
@@ -0,0 +1,99 @@
+package kafka
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/eshop/kafka-service-go/internal/config"
+)
+
+// ReplayOptions controls a dead-letter replay
+type ReplayOptions struct {
+	Limit  int           // Stop after this many messages, 0 for all
+	Idle   time.Duration // Stop once no message arrives for this long
+	DryRun bool          // Print what would be replayed without producing or committing
+}
+
+// ReplayDLQ moves dead-lettered events back into the topic they originally
+// came from. Failure headers are dropped so they get a fresh set of retries;
+// the original position and timestamp are kept, so dedup still recognises
+// events that were partially applied. It returns the number of messages replayed.
+func ReplayDLQ(cfg *config.Config, opts ReplayOptions) (int, error) {
+	conf := clientConfig(cfg)
+	conf["group.id"] = cfg.KafkaGroupID + "-dlq-replay"
+	conf["auto.offset.reset"] = "earliest"
+	conf["enable.auto.commit"] = false
+
+	consumer, err := kafka.NewConsumer(&conf)
+	if err != nil {
+		return 0, err
+	}
+	defer consumer.Close()
+
+	producer, err := newProducer(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer producer.Close()
+
+	if err := consumer.Subscribe(cfg.KafkaDLQTopic, nil); err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	lastMessage := time.Now()
+	for opts.Limit == 0 || replayed < opts.Limit {
+		msg, err := consumer.ReadMessage(time.Second)
+		if err != nil {
+			if err.(kafka.Error).Code() != kafka.ErrTimedOut {
+				return replayed, err
+			}
+			if time.Since(lastMessage) >= opts.Idle {
+				break
+			}
+			continue
+		}
+		lastMessage = time.Now()
+
+		topic, ok := header(msg, headerOriginalTopic)
+		if !ok {
+			topic = cfg.KafkaTopic
+		}
+		reason, _ := header(msg, headerError)
+
+		if opts.DryRun {
+			log.Printf("Would replay %v to %s (%d attempts, %s): %s", msg.TopicPartition, topic, attempts(msg), reason, msg.Value)
+			replayed++
+			continue
+		}
+
+		if err := produce(producer, replayMessage(msg, topic)); err != nil {
+			return replayed, fmt.Errorf("failed to replay %v: %w", msg.TopicPartition, err)
+		}
+		if _, err := consumer.CommitMessage(msg); err != nil {
+			return replayed, fmt.Errorf("failed to commit %v: %w", msg.TopicPartition, err)
+		}
+		log.Printf("Replayed %v to %s (%s)", msg.TopicPartition, topic, reason)
+		replayed++
+	}
+	return replayed, nil
+}
+
+func replayMessage(msg *kafka.Message, topic string) *kafka.Message {
+	var headers []kafka.Header
+	for _, h := range msg.Headers {
+		switch h.Key {
+		case headerOriginalTopic, headerOriginalPartition, headerOriginalOffset:
+			headers = append(headers, h)
+		}
+	}
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
+		Timestamp:      msg.Timestamp,
+	}
+}
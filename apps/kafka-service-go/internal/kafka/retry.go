@@ -0,0 +1,157 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/eshop/kafka-service-go/internal/config"
+)
+
+// Headers on retried and dead-lettered messages
+const (
+	headerAttempts          = "x-attempts"  // Failed attempts so far
+	headerError             = "x-error"     // Reason for the last failure
+	headerRetryAt           = "x-retry-at"  // Unix ms before which a retry isn't processed
+	headerFailedAt          = "x-failed-at" // RFC 3339
+	headerOriginalTopic     = "x-original-topic"
+	headerOriginalPartition = "x-original-partition"
+	headerOriginalOffset    = "x-original-offset"
+)
+
+// permanentError marks failures that retrying can't fix, e.g. malformed JSON.
+// Those go straight to the dead-letter topic.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// retryTopic is a topic whose messages wait delay before being processed
+type retryTopic struct {
+	name  string
+	delay time.Duration
+}
+
+// retryTopics names one topic per delay, e.g. users-events.retry.1m
+func retryTopics(topic string, delays []time.Duration) []retryTopic {
+	topics := make([]retryTopic, len(delays))
+	for i, delay := range delays {
+		topics[i] = retryTopic{name: topic + ".retry." + durationLabel(delay), delay: delay}
+	}
+	return topics
+}
+
+func durationLabel(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
+
+// clientConfig is the connection configuration shared by consumers and producers
+func clientConfig(cfg *config.Config) kafka.ConfigMap {
+	return kafka.ConfigMap{
+		"bootstrap.servers": cfg.KafkaBrokerURL,
+		// SASL configuration if needed (based on logger-service)
+		"security.protocol": "SASL_SSL",
+		"sasl.mechanisms":   "PLAIN",
+		"sasl.username":     cfg.KafkaAPIKey,
+		"sasl.password":     cfg.KafkaAPISecret,
+	}
+}
+
+func newProducer(cfg *config.Config) (*kafka.Producer, error) {
+	conf := clientConfig(cfg)
+	conf["enable.idempotence"] = true
+	return kafka.NewProducer(&conf)
+}
+
+// produce sends msg and waits for the broker to acknowledge it
+func produce(producer *kafka.Producer, msg *kafka.Message) error {
+	delivery := make(chan kafka.Event, 1)
+	if err := producer.Produce(msg, delivery); err != nil {
+		return err
+	}
+	report, ok := (<-delivery).(*kafka.Message)
+	if !ok {
+		return errors.New("unexpected delivery report")
+	}
+	return report.TopicPartition.Error
+}
+
+// failed builds the message that carries a failed event to topic. Original
+// position headers are kept from earlier hops so they always point at the
+// first delivery, and the timestamp is kept so events without an eventId
+// still hash to the same dedup key.
+func failed(msg *kafka.Message, topic string, attempts int, cause error, retryAt time.Time) *kafka.Message {
+	headers := []kafka.Header{
+		{Key: headerAttempts, Value: []byte(strconv.Itoa(attempts))},
+		{Key: headerError, Value: []byte(cause.Error())},
+		{Key: headerFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+	}
+	if !retryAt.IsZero() {
+		headers = append(headers, kafka.Header{Key: headerRetryAt, Value: []byte(strconv.FormatInt(retryAt.UnixMilli(), 10))})
+	}
+
+	if _, ok := header(msg, headerOriginalTopic); ok {
+		for _, key := range []string{headerOriginalTopic, headerOriginalPartition, headerOriginalOffset} {
+			value, _ := header(msg, key)
+			headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+		}
+	} else {
+		headers = append(headers,
+			kafka.Header{Key: headerOriginalTopic, Value: []byte(*msg.TopicPartition.Topic)},
+			kafka.Header{Key: headerOriginalPartition, Value: []byte(strconv.Itoa(int(msg.TopicPartition.Partition)))},
+			kafka.Header{Key: headerOriginalOffset, Value: []byte(strconv.FormatInt(int64(msg.TopicPartition.Offset), 10))},
+		)
+	}
+
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
+		Timestamp:      msg.Timestamp,
+	}
+}
+
+func header(msg *kafka.Message, key string) (string, bool) {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+// attempts returns how often the message has failed before
+func attempts(msg *kafka.Message) int {
+	value, _ := header(msg, headerAttempts)
+	n, _ := strconv.Atoi(value)
+	return n
+}
+
+// retryAt returns when a retried message becomes due
+func retryAt(msg *kafka.Message) (time.Time, bool) {
+	value, ok := header(msg, headerRetryAt)
+	if !ok {
+		return time.Time{}, false
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
@@ -0,0 +1,162 @@
+package kafka
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// workerPool processes messages concurrently. Messages are hashed to a worker
+// by user, so one user's events are still applied in order while different
+// users proceed in parallel.
+type workerPool struct {
+	queues   []chan *kafka.Message
+	wg       sync.WaitGroup // Running workers
+	pending  sync.WaitGroup // Dispatched messages not yet finished
+	inFlight atomic.Int64
+}
+
+// newWorkerPool starts workers goroutines running handle. Each queue holds up
+// to maxInFlight messages, so dispatch never blocks while the consumer keeps
+// in-flight messages under that.
+func newWorkerPool(workers, maxInFlight int, handle func(*kafka.Message)) *workerPool {
+	p := &workerPool{queues: make([]chan *kafka.Message, workers)}
+	for i := range p.queues {
+		queue := make(chan *kafka.Message, maxInFlight)
+		p.queues[i] = queue
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for msg := range queue {
+				handle(msg)
+				p.inFlight.Add(-1)
+				p.pending.Done()
+			}
+		}()
+	}
+	return p
+}
+
+func (p *workerPool) dispatch(msg *kafka.Message) {
+	p.pending.Add(1)
+	p.inFlight.Add(1)
+	p.queues[p.worker(msg)] <- msg
+}
+
+func (p *workerPool) worker(msg *kafka.Message) int {
+	h := fnv.New32a()
+	h.Write([]byte(orderingKey(msg)))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// drain waits for every dispatched message to finish
+func (p *workerPool) drain() {
+	p.pending.Wait()
+}
+
+// stop lets the workers finish their queues and waits for them to exit
+func (p *workerPool) stop() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+// orderingKey is the user a message belongs to: the message key when the
+// producer sets one, otherwise the userId in the payload. Undecodable messages
+// share the empty key; they fail permanently anyway.
+func orderingKey(msg *kafka.Message) string {
+	if len(msg.Key) > 0 {
+		return string(msg.Key)
+	}
+	var payload struct {
+		UserID string `json:"userId"` // v0
+		Data   struct {
+			UserID string `json:"userId"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Value, &payload); err != nil {
+		return ""
+	}
+	if payload.Data.UserID != "" {
+		return payload.Data.UserID
+	}
+	return payload.UserID
+}
+
+// offsetTracker stores a partition's offset only once every message before it
+// has finished. Workers finish out of order, so a later offset may be done
+// while an earlier one is still in flight.
+type offsetTracker struct {
+	consumer *kafka.Consumer
+
+	mu         sync.Mutex
+	partitions map[string]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	partition kafka.TopicPartition
+	pending   []kafka.Offset // Dispatched, in offset order
+	done      map[kafka.Offset]bool
+}
+
+func newOffsetTracker(consumer *kafka.Consumer) *offsetTracker {
+	return &offsetTracker{consumer: consumer, partitions: make(map[string]*partitionOffsets)}
+}
+
+// start records msg as dispatched
+func (t *offsetTracker) start(msg *kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := partitionKey(msg.TopicPartition)
+	offsets, ok := t.partitions[key]
+	if !ok {
+		partition := msg.TopicPartition
+		partition.Error = nil
+		offsets = &partitionOffsets{partition: partition, done: make(map[kafka.Offset]bool)}
+		t.partitions[key] = offsets
+	}
+	offsets.pending = append(offsets.pending, msg.TopicPartition.Offset)
+}
+
+// finish records msg as persisted and stores the partition's offset past the
+// longest finished run
+func (t *offsetTracker) finish(msg *kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	offsets, ok := t.partitions[partitionKey(msg.TopicPartition)]
+	if !ok {
+		return
+	}
+	offsets.done[msg.TopicPartition.Offset] = true
+
+	advanced := false
+	for len(offsets.pending) > 0 && offsets.done[offsets.pending[0]] {
+		offsets.partition.Offset = offsets.pending[0] + 1
+		delete(offsets.done, offsets.pending[0])
+		offsets.pending = offsets.pending[1:]
+		advanced = true
+	}
+	if !advanced {
+		return
+	}
+	if _, err := t.consumer.StoreOffsets([]kafka.TopicPartition{offsets.partition}); err != nil {
+		log.Printf("Error storing offset for %v: %v", offsets.partition, err)
+	}
+}
+
+// forget drops revoked partitions
+func (t *offsetTracker) forget(partitions []kafka.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, partition := range partitions {
+		delete(t.partitions, partitionKey(partition))
+	}
+}
@@ -0,0 +1,32 @@
+// Package metrics publishes consumer counters through expvar, served as JSON
+// on /metrics
+package metrics
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+)
+
+var (
+	EventsProcessed   = expvar.NewInt("events_processed")
+	DuplicatesDropped = expvar.NewInt("duplicates_dropped")
+	BotEventsDropped  = expvar.NewInt("bot_events_dropped")
+)
+
+// Serve exposes /metrics and /health on port in the background
+func Serve(port string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", expvar.Handler())
+	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+
+	go func() {
+		log.Printf("Metrics listening on :%s", port)
+		if err := http.ListenAndServe(":"+port, mux); err != nil {
+			log.Printf("Metrics server stopped: %v", err)
+		}
+	}()
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"log"
 	"time"
 
@@ -20,6 +21,13 @@ func NewAnalyticsService(db *db.MongoDB) *AnalyticsService {
 }
 
 type Event struct {
+	ID string `json:"-"` // Dedup key set by the consumer, makes writes idempotent
+
+	// From the v1 envelope, see internal/events
+	EventID   string    `json:"-"`
+	Source    string    `json:"-"`
+	Timestamp time.Time `json:"-"`
+
 	UserID    string `json:"userId"`
 	Action    string `json:"action"`
 	ProductID string `json:"productId"`
@@ -27,6 +35,7 @@ type Event struct {
 	Country   string `json:"country"`
 	City      string `json:"city"`
 	Device    string `json:"device"`
+	BotScore  int    `json:"botScore"` // The gateway's X-Bot-Score for the request behind the event, if flagged
 }
 
 type ActionEntry struct {
@@ -36,134 +45,127 @@ type ActionEntry struct {
 	Timestamp string `bson:"timestamp" json:"timestamp"`
 }
 
-func (s *AnalyticsService) UpdateUserAnalytics(ctx context.Context, event Event) error {
-	collection := s.db.UserAnalytics()
-
-	// 1. Fetch existing data
-	var userAnalytics struct {
-		Actions []ActionEntry `bson:"actions"`
-	}
-	err := collection.FindOne(ctx, bson.M{"userId": event.UserID}, options.FindOne().SetProjection(bson.M{"actions": 1})).Decode(&userAnalytics)
-	if err != nil && err != mongo.ErrNoDocuments {
-		log.Printf("Error fetching user analytics: %v", err)
-		return err
-	}
-
-	updatedActions := userAnalytics.Actions
-	if updatedActions == nil {
-		updatedActions = []ActionEntry{}
-	}
+// maxUserActions bounds a user's action history, oldest entries go first
+const maxUserActions = 100
 
-	actionExists := false
-	for _, entry := range updatedActions {
-		if entry.ProductID == event.ProductID && entry.Action == event.Action {
-			actionExists = true
-			break
-		}
-	}
-
-	// Logic from TS service
-	if event.Action == "product_view" {
-		updatedActions = append(updatedActions, ActionEntry{
-			ProductID: event.ProductID,
-			ShopID:    event.ShopID,
-			Action:    event.Action,
-			Timestamp: time.Now().Format(time.RFC3339),
-		})
-	} else if (event.Action == "add_to_cart" || event.Action == "add_to_wishlist") && !actionExists {
-		updatedActions = append(updatedActions, ActionEntry{
-			ProductID: event.ProductID,
-			ShopID:    event.ShopID,
-			Action:    event.Action,
-			Timestamp: time.Now().Format(time.RFC3339),
-		})
-	} else if event.Action == "remove_from_cart" {
-		// Filter out add_to_cart for this product
-		newActions := []ActionEntry{}
-		for _, entry := range updatedActions {
-			if !(entry.ProductID == event.ProductID && entry.Action == "add_to_cart") {
-				newActions = append(newActions, entry)
-			}
-		}
-		updatedActions = newActions
-	} else if event.Action == "remove_from_wishlist" {
-		// Filter out add_to_wishlist for this product
-		newActions := []ActionEntry{}
-		for _, entry := range updatedActions {
-			if !(entry.ProductID == event.ProductID && entry.Action == "add_to_wishlist") {
-				newActions = append(newActions, entry)
-			}
-		}
-		updatedActions = newActions
+// UpdateUserAnalytics records the event in the user's action history. Each
+// action is one atomic update, so concurrent events for the same user can't
+// overwrite each other's changes.
+func (s *AnalyticsService) UpdateUserAnalytics(ctx context.Context, event Event) error {
+	now := time.Now()
+	entry := ActionEntry{
+		ProductID: event.ProductID,
+		ShopID:    event.ShopID,
+		Action:    event.Action,
+		Timestamp: now.Format(time.RFC3339),
 	}
 
-	// Keep only last 100 actions
-	if len(updatedActions) > 100 {
-		updatedActions = updatedActions[1:] // Shift (remove first)
+	set := bson.M{
+		"lastVisited": now,
+		"updatedAt":   now,
 	}
-
-	extraFields := bson.M{}
 	if event.Country != "" {
-		extraFields["country"] = event.Country
+		set["country"] = event.Country
 	}
 	if event.City != "" {
-		extraFields["city"] = event.City
+		set["city"] = event.City
 	}
 	if event.Device != "" {
-		extraFields["device"] = event.Device
-	}
-
-	update := bson.M{
-		"$set": bson.M{
-			"lastVisited": time.Now(),
-			"actions":     updatedActions,
-			"updatedAt":   time.Now(),
-		},
-	}
-	// Merge extra fields into $set
-	for k, v := range extraFields {
-		update["$set"].(bson.M)[k] = v
-	}
-
-	// Upsert
-	opts := options.Update().SetUpsert(true)
-	// We also need to set createdAt on insert, but $setOnInsert is cleaner.
-	update["$setOnInsert"] = bson.M{
-		"createdAt": time.Now(),
-		"userId":    event.UserID,
+		set["device"] = event.Device
+	}
+
+	filter := bson.M{"userId": event.UserID}
+	var update interface{}
+
+	switch event.Action {
+	case "add_to_cart", "add_to_wishlist":
+		// Add-if-absent needs to look at the array, which takes a pipeline
+		update = addActionIfAbsent(filter, set, entry, event.ID, now)
+	default:
+		operators := bson.M{
+			"$set": set,
+			"$setOnInsert": bson.M{
+				"createdAt": now,
+				"userId":    event.UserID,
+			},
+		}
+		switch event.Action {
+		case "product_view":
+			operators["$push"] = bson.M{"actions": bson.M{"$each": bson.A{entry}, "$slice": -maxUserActions}}
+		case "remove_from_cart":
+			operators["$pull"] = bson.M{"actions": bson.M{"productId": event.ProductID, "action": "add_to_cart"}}
+		case "remove_from_wishlist":
+			operators["$pull"] = bson.M{"actions": bson.M{"productId": event.ProductID, "action": "add_to_wishlist"}}
+		}
+		idempotent(filter, operators, event.ID)
+		update = operators
 	}
 
-	_, err = collection.UpdateOne(ctx, bson.M{"userId": event.UserID}, update, opts)
+	err := guardedUpsert(ctx, s.db.UserAnalytics(), filter, update, options.Update().SetUpsert(true))
 	if err != nil {
 		log.Printf("Error updating user analytics: %v", err)
 		return err
 	}
 
-	return s.UpdateProductAnalytics(ctx, event)
+	return nil
 }
 
-func (s *AnalyticsService) UpdateProductAnalytics(ctx context.Context, event Event) error {
-	if event.ProductID == "" {
-		return nil
-	}
-
-	collection := s.db.ProductAnalytics()
+// addActionIfAbsent builds a pipeline update that appends entry unless the
+// user already has that action for the product. Values from the event are
+// wrapped in $literal so they can't be read as field paths or operators.
+func addActionIfAbsent(filter, set bson.M, entry ActionEntry, eventID string, now time.Time) bson.A {
+	actions := bson.M{"$ifNull": bson.A{"$actions", bson.A{}}}
+	present := bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+		"input": actions,
+		"as":    "entry",
+		"in": bson.M{"$and": bson.A{
+			bson.M{"$eq": bson.A{"$$entry.productId", bson.M{"$literal": entry.ProductID}}},
+			bson.M{"$eq": bson.A{"$$entry.action", bson.M{"$literal": entry.Action}}},
+		}},
+	}}}}
+
+	stage := bson.M{
+		"actions": bson.M{"$cond": bson.A{
+			present,
+			actions,
+			bson.M{"$slice": bson.A{bson.M{"$concatArrays": bson.A{actions, bson.A{bson.M{"$literal": entry}}}}, -maxUserActions}},
+		}},
+		"createdAt": bson.M{"$ifNull": bson.A{"$createdAt", now}},
+	}
+	for field, value := range set {
+		stage[field] = bson.M{"$literal": value}
+	}
+
+	if eventID != "" {
+		filter["appliedEvents"] = bson.M{"$ne": eventID}
+		applied := bson.M{"$ifNull": bson.A{"$appliedEvents", bson.A{}}}
+		stage["appliedEvents"] = bson.M{"$slice": bson.A{bson.M{"$concatArrays": bson.A{applied, bson.A{bson.M{"$literal": eventID}}}}, -appliedEventsKept}}
+	}
+
+	return bson.A{bson.M{"$set": stage}}
+}
 
+// productIncrement returns the productAnalytics counters an action moves
+func productIncrement(action string) bson.M {
 	inc := bson.M{}
-	if event.Action == "product_view" {
+	if action == "product_view" {
 		inc["views"] = 1
-	} else if event.Action == "add_to_cart" {
+	} else if action == "add_to_cart" {
 		inc["cartAdds"] = 1
-	} else if event.Action == "remove_from_cart" {
+	} else if action == "remove_from_cart" {
 		inc["cartAdds"] = -1
-	} else if event.Action == "add_to_wishlist" {
+	} else if action == "add_to_wishlist" {
 		inc["wishlistAdds"] = 1
-	} else if event.Action == "remove_from_wishlist" {
+	} else if action == "remove_from_wishlist" {
 		inc["wishlistAdds"] = -1
-	} else if event.Action == "purchase" {
+	} else if action == "purchase" {
 		inc["purchases"] = 1
 	}
+	return inc
+}
 
+// productUpdate builds the productAnalytics upsert applying inc
+func productUpdate(productID, shopID string, inc bson.M) (bson.M, bson.M) {
 	update := bson.M{
 		"$set": bson.M{
 			"lastViewedAt": time.Now(),
@@ -176,42 +178,76 @@ func (s *AnalyticsService) UpdateProductAnalytics(ctx context.Context, event Eve
 
 	update["$setOnInsert"] = bson.M{
 		"createdAt": time.Now(),
-		"productId": event.ProductID,
-		"shopId":    event.ShopID, // Note: shopId might be null if not provided in event, but schema says unique? No, shopId is unique in ProductAnalytics?
-		// Schema: shopId String @unique @db.ObjectId. Wait.
-		// model productAnalytics { ... shopId String @unique ... productId String @unique ... }
-		// This implies one document per shop? Or one per product?
-		// If both are unique, it's 1:1. But that doesn't make sense if a shop has multiple products.
-		// Let's re-read schema.
-		// model productAnalytics { ... shopId String @unique ... productId String @unique ... }
-		// This seems to imply a productAnalytics document is unique to a product AND unique to a shop?
-		// That would mean a shop can only have ONE product analytics record? That seems wrong.
-		// Maybe it means the pair is unique? But it says @unique on each field.
-		// If it's @unique on shopId, then a shop can only appear once in the entire collection.
-		// That would mean we can't store analytics for multiple products of the same shop.
-		// Let's assume the schema might be slightly misleading or I'm misinterpreting.
-		// But in TS code:
-		// create: { productId: event.productId, shopId: event.shopId || null, ... }
-		// It sets shopId.
-		// If the schema enforces uniqueness on shopId, this will fail for the second product of the same shop.
-		// However, we are migrating logic, not fixing schema (unless it blocks us).
-		// We'll follow the TS logic.
-	}
-
-	// If shopId is missing in event, we might want to fetch it or leave it null (if schema allows).
+		"productId": productID,
+	}
+
 	// TS code: shopId: event.shopId || null
-	if event.ShopID != "" {
-		update["$setOnInsert"].(bson.M)["shopId"] = event.ShopID
+	if shopID != "" {
+		update["$setOnInsert"].(bson.M)["shopId"] = shopID
 	} else {
 		update["$setOnInsert"].(bson.M)["shopId"] = nil
 	}
 
-	opts := options.Update().SetUpsert(true)
-	_, err := collection.UpdateOne(ctx, bson.M{"productId": event.ProductID}, update, opts)
-	if err != nil {
-		log.Printf("Error updating product analytics: %v", err)
-		return err
+	return bson.M{"productId": productID}, update
+}
+
+// appliedEventsKept bounds the per-document list of applied event IDs. It only
+// has to cover the redelivery window, i.e. events since the last offset commit.
+const appliedEventsKept = 200
+
+// idempotent guards an upsert so it only applies an event the document hasn't
+// seen yet, and records the event as applied in the same atomic update
+func idempotent(filter, update bson.M, eventID string) {
+	if eventID == "" {
+		return
+	}
+	filter["appliedEvents"] = bson.M{"$ne": eventID}
+	push, ok := update["$push"].(bson.M)
+	if !ok {
+		push = bson.M{}
+		update["$push"] = push
 	}
+	push["appliedEvents"] = bson.M{"$each": bson.A{eventID}, "$slice": -appliedEventsKept}
+}
 
-	return nil
+// guardedUpsert runs an upsert guarded on appliedEvents. A duplicate key error
+// on the document's own key means it exists and already has the event (the
+// filter didn't match, so the upsert tried to insert a second document), or
+// that a concurrent upsert created the document first. Retrying once tells
+// them apart: the retry matches the document unless it has the event.
+// Duplicates on any other unique index are real failures and are returned.
+func guardedUpsert(ctx context.Context, collection *mongo.Collection, filter bson.M, update interface{}, opts *options.UpdateOptions) error {
+	_, err := collection.UpdateOne(ctx, filter, update, opts)
+	if duplicateOnFilter(err, filter) {
+		_, err = collection.UpdateOne(ctx, filter, update, opts)
+	}
+	if duplicateOnFilter(err, filter) {
+		return nil
+	}
+	return err
+}
+
+// duplicateOnFilter reports whether err is a duplicate key error on a unique
+// index made up of fields the filter matches on, such as userId or productId
+func duplicateOnFilter(err error, filter bson.M) bool {
+	var writeErr mongo.WriteException
+	if !mongo.IsDuplicateKeyError(err) || !errors.As(err, &writeErr) || len(writeErr.WriteErrors) == 0 {
+		return false
+	}
+	for _, failure := range writeErr.WriteErrors {
+		keyPattern, ok := failure.Raw.Lookup("keyPattern").DocumentOK()
+		if !ok {
+			return false
+		}
+		fields, err := keyPattern.Elements()
+		if err != nil || len(fields) == 0 {
+			return false
+		}
+		for _, field := range fields {
+			if _, ok := filter[field.Key()]; !ok {
+				return false
+			}
+		}
+	}
+	return true
 }
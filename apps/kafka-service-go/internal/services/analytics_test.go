@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eshop/kafka-service-go/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newTestService connects to the Mongo at MONGODB_TEST_URL and gives the test
+// a database of its own, dropped afterwards. The operators under test need a
+// real server, so the tests are skipped without one.
+func newTestService(t *testing.T) *AnalyticsService {
+	t.Helper()
+
+	uri := os.Getenv("MONGODB_TEST_URL")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URL not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("failed to connect to MongoDB: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("failed to ping MongoDB: %v", err)
+	}
+
+	database := client.Database(fmt.Sprintf("kafka_service_test_%d", time.Now().UnixNano()))
+	mongoDB := &db.MongoDB{Client: client, DB: database}
+	if err := mongoDB.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("failed to create indexes: %v", err)
+	}
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		database.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return NewAnalyticsService(mongoDB)
+}
+
+var eventSeq atomic.Int64
+
+// userEvent builds an event with a dedup key of its own
+func userEvent(userID, action, productID string) Event {
+	id := fmt.Sprintf("test-%d", eventSeq.Add(1))
+	return Event{ID: id, UserID: userID, Action: action, ProductID: productID, ShopID: "shop-1"}
+}
+
+func userActions(t *testing.T, s *AnalyticsService, userID string) []ActionEntry {
+	t.Helper()
+
+	var doc struct {
+		Actions []ActionEntry `bson:"actions"`
+	}
+	err := s.db.UserAnalytics().FindOne(context.Background(), bson.M{"userId": userID}).Decode(&doc)
+	if err != nil {
+		t.Fatalf("failed to read user analytics: %v", err)
+	}
+	return doc.Actions
+}
+
+func apply(t *testing.T, s *AnalyticsService, event Event) {
+	t.Helper()
+
+	if err := s.UpdateUserAnalytics(context.Background(), event); err != nil {
+		t.Fatalf("UpdateUserAnalytics(%s %s): %v", event.Action, event.ProductID, err)
+	}
+}
+
+func TestUpdateUserAnalyticsConcurrentViews(t *testing.T) {
+	s := newTestService(t)
+
+	const views = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, views)
+	for i := 0; i < views; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- s.UpdateUserAnalytics(context.Background(), userEvent("user-1", "product_view", fmt.Sprintf("product-%d", i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("UpdateUserAnalytics: %v", err)
+		}
+	}
+
+	if got := len(userActions(t, s, "user-1")); got != views {
+		t.Fatalf("got %d actions, want %d", got, views)
+	}
+}
+
+func TestUpdateUserAnalyticsTrimsHistory(t *testing.T) {
+	s := newTestService(t)
+
+	total := maxUserActions + 20
+	for i := 0; i < total; i++ {
+		apply(t, s, userEvent("user-1", "product_view", fmt.Sprintf("product-%d", i)))
+	}
+
+	actions := userActions(t, s, "user-1")
+	if len(actions) != maxUserActions {
+		t.Fatalf("got %d actions, want %d", len(actions), maxUserActions)
+	}
+	if first, want := actions[0].ProductID, fmt.Sprintf("product-%d", total-maxUserActions); first != want {
+		t.Errorf("oldest action is for %s, want %s", first, want)
+	}
+	if last, want := actions[len(actions)-1].ProductID, fmt.Sprintf("product-%d", total-1); last != want {
+		t.Errorf("newest action is for %s, want %s", last, want)
+	}
+
+	// Adds trim the same way
+	apply(t, s, userEvent("user-1", "add_to_cart", "product-new"))
+	actions = userActions(t, s, "user-1")
+	if len(actions) != maxUserActions {
+		t.Fatalf("got %d actions after add_to_cart, want %d", len(actions), maxUserActions)
+	}
+	if last := actions[len(actions)-1]; last.ProductID != "product-new" || last.Action != "add_to_cart" {
+		t.Errorf("newest action is %+v, want add_to_cart for product-new", last)
+	}
+}
+
+func TestUpdateUserAnalyticsAddIfAbsent(t *testing.T) {
+	s := newTestService(t)
+
+	apply(t, s, userEvent("user-1", "add_to_cart", "product-1"))
+	apply(t, s, userEvent("user-1", "add_to_cart", "product-1"))
+	apply(t, s, userEvent("user-1", "add_to_wishlist", "product-1"))
+
+	// Concurrent adds of the same product, the first one to create the user included
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.UpdateUserAnalytics(context.Background(), userEvent("user-2", "add_to_cart", "product-2")); err != nil {
+				t.Errorf("UpdateUserAnalytics: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	actions := userActions(t, s, "user-1")
+	if len(actions) != 2 {
+		t.Fatalf("got %d actions for user-1, want 2: %+v", len(actions), actions)
+	}
+	if actions[0].Action != "add_to_cart" || actions[1].Action != "add_to_wishlist" {
+		t.Errorf("got actions %+v, want add_to_cart then add_to_wishlist", actions)
+	}
+	if got := len(userActions(t, s, "user-2")); got != 1 {
+		t.Errorf("got %d actions for user-2, want 1", got)
+	}
+}
+
+func TestUpdateUserAnalyticsRemove(t *testing.T) {
+	s := newTestService(t)
+
+	apply(t, s, userEvent("user-1", "product_view", "product-1"))
+	apply(t, s, userEvent("user-1", "add_to_cart", "product-1"))
+	apply(t, s, userEvent("user-1", "add_to_wishlist", "product-1"))
+	apply(t, s, userEvent("user-1", "add_to_cart", "product-2"))
+
+	apply(t, s, userEvent("user-1", "remove_from_cart", "product-1"))
+
+	actions := userActions(t, s, "user-1")
+	for _, action := range actions {
+		if action.ProductID == "product-1" && action.Action == "add_to_cart" {
+			t.Fatalf("add_to_cart for product-1 was not removed: %+v", actions)
+		}
+	}
+	if len(actions) != 3 {
+		t.Errorf("got %d actions, want 3: %+v", len(actions), actions)
+	}
+
+	apply(t, s, userEvent("user-1", "remove_from_wishlist", "product-1"))
+	if got := len(userActions(t, s, "user-1")); got != 2 {
+		t.Errorf("got %d actions after remove_from_wishlist, want 2", got)
+	}
+}
+
+func TestUpdateUserAnalyticsRedelivery(t *testing.T) {
+	s := newTestService(t)
+
+	view := userEvent("user-1", "product_view", "product-1")
+	apply(t, s, view)
+	apply(t, s, view)
+
+	if got := len(userActions(t, s, "user-1")); got != 1 {
+		t.Fatalf("got %d actions after a redelivered view, want 1", got)
+	}
+}
@@ -0,0 +1,465 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/eshop/kafka-service-go/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Funnel steps. The main path is view → cart → purchase, and each step only
+// counts after the previous one within the same session; wishlist branches off
+// view.
+const (
+	stepView     = "view"
+	stepCart     = "cart"
+	stepWishlist = "wishlist"
+	stepPurchase = "purchase"
+)
+
+// funnelSteps maps tracked actions to funnel steps. Purchases made through
+// order-service are read from orders rather than tracked.
+var funnelSteps = map[string]string{
+	"product_view":    stepView,
+	"add_to_cart":     stepCart,
+	"add_to_wishlist": stepWishlist,
+	"purchase":        stepPurchase,
+}
+
+// funnelEvent is the document recorded for an event that is a funnel step, or
+// nil if it isn't one. It is keyed by the event ID, so redeliveries are
+// recorded once.
+func funnelEvent(event Event, retention time.Duration) bson.M {
+	step, ok := funnelSteps[event.Action]
+	if !ok || event.UserID == "" || event.ProductID == "" {
+		return nil
+	}
+	at := eventTime(event)
+	return bson.M{
+		"_id":       event.ID,
+		"userId":    event.UserID,
+		"productId": event.ProductID,
+		"shopId":    event.ShopID,
+		"step":      step,
+		"at":        at,
+		"expiresAt": at.Add(retention),
+	}
+}
+
+// Funnels computes per-product and per-shop conversion funnels over sliding
+// windows ending now. Each user's funnel events are split into sessions
+// wherever they pause for longer than sessionGap, and a session counts toward
+// a window if it started inside it.
+type Funnels struct {
+	db         *db.MongoDB
+	interval   time.Duration
+	windows    []time.Duration
+	sessionGap time.Duration
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func NewFunnels(db *db.MongoDB, interval time.Duration, windows []time.Duration, sessionGap time.Duration) *Funnels {
+	return &Funnels{
+		db:         db,
+		interval:   interval,
+		windows:    windows,
+		sessionGap: sessionGap,
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+}
+
+// Retention is how long funnel events must be kept to cover every window
+func (f *Funnels) Retention() time.Duration {
+	return slices.Max(f.windows) + f.sessionGap
+}
+
+// Start computes funnels now and then every interval in the background
+func (f *Funnels) Start() {
+	go func() {
+		defer close(f.stopped)
+
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+
+		for {
+			f.run()
+			select {
+			case <-f.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the periodic computation and waits for a running one
+func (f *Funnels) Close() {
+	close(f.stop)
+	<-f.stopped
+}
+
+func (f *Funnels) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), f.interval)
+	defer cancel()
+
+	started := time.Now()
+	if err := f.Compute(ctx, started.UTC()); err != nil {
+		log.Printf("Error computing funnels: %v", err)
+		return
+	}
+	log.Printf("Computed funnels in %s", time.Since(started).Round(time.Millisecond))
+}
+
+// funnelProgress is how far one session got through a funnel
+type funnelProgress struct {
+	viewed, carted, wishlisted, purchased bool
+}
+
+func (p *funnelProgress) advance(step string) {
+	switch step {
+	case stepView:
+		p.viewed = true
+	case stepCart:
+		p.carted = p.carted || p.viewed
+	case stepWishlist:
+		p.wishlisted = p.wishlisted || p.viewed
+	case stepPurchase:
+		p.purchased = p.purchased || p.carted
+	}
+}
+
+// funnelCounts is the number of sessions that reached each step
+type funnelCounts struct {
+	shopID                                                         string
+	viewSessions, cartSessions, wishlistSessions, purchaseSessions int
+}
+
+func (c *funnelCounts) add(p *funnelProgress) {
+	if !p.viewed {
+		return
+	}
+	c.viewSessions++
+	if p.carted {
+		c.cartSessions++
+	}
+	if p.wishlisted {
+		c.wishlistSessions++
+	}
+	if p.purchased {
+		c.purchaseSessions++
+	}
+}
+
+// session is one user's funnel progress per product and per shop
+type session struct {
+	start    time.Time
+	last     time.Time
+	products map[string]*funnelProgress
+	shops    map[string]*funnelProgress
+	shopOf   map[string]string // Product to shop
+}
+
+func newSession(at time.Time) *session {
+	return &session{
+		start:    at,
+		last:     at,
+		products: map[string]*funnelProgress{},
+		shops:    map[string]*funnelProgress{},
+		shopOf:   map[string]string{},
+	}
+}
+
+func (s *session) advance(step funnelStep) {
+	s.last = step.At
+	progress(s.products, step.ProductID).advance(step.Step)
+	if step.ShopID != "" {
+		s.shopOf[step.ProductID] = step.ShopID
+		progress(s.shops, step.ShopID).advance(step.Step)
+	}
+}
+
+func progress(m map[string]*funnelProgress, key string) *funnelProgress {
+	p, ok := m[key]
+	if !ok {
+		p = &funnelProgress{}
+		m[key] = p
+	}
+	return p
+}
+
+// funnelStep is one step read back from funnelEvents or orders
+type funnelStep struct {
+	UserID    string    `bson:"userId"`
+	ProductID string    `bson:"productId"`
+	ShopID    string    `bson:"shopId"`
+	Step      string    `bson:"step"`
+	At        time.Time `bson:"at"`
+}
+
+// windowCounts accumulates the sessions that started in one window
+type windowCounts struct {
+	label    string
+	start    time.Time
+	products map[string]*funnelCounts
+	shops    map[string]*funnelCounts
+}
+
+func (w *windowCounts) add(s *session) {
+	if s.start.Before(w.start) {
+		return
+	}
+	for productID, p := range s.products {
+		counts, ok := w.products[productID]
+		if !ok {
+			counts = &funnelCounts{}
+			w.products[productID] = counts
+		}
+		if counts.shopID == "" {
+			counts.shopID = s.shopOf[productID]
+		}
+		counts.add(p)
+	}
+	for shopID, p := range s.shops {
+		counts, ok := w.shops[shopID]
+		if !ok {
+			counts = &funnelCounts{shopID: shopID}
+			w.shops[shopID] = counts
+		}
+		counts.add(p)
+	}
+}
+
+// Compute rebuilds the funnels of every window ending at now. Funnels whose
+// product or shop has no sessions left in a window are removed.
+func (f *Funnels) Compute(ctx context.Context, now time.Time) error {
+	windows := make([]*windowCounts, len(f.windows))
+	for i, window := range f.windows {
+		windows[i] = &windowCounts{
+			label:    windowLabel(window),
+			start:    now.Add(-window),
+			products: map[string]*funnelCounts{},
+			shops:    map[string]*funnelCounts{},
+		}
+	}
+
+	since := now.Add(-slices.Max(f.windows))
+	cursor, err := f.db.FunnelEvents().Aggregate(ctx, f.stepsPipeline(since), options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return fmt.Errorf("failed to read funnel events: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	// Steps arrive sorted by user and time, so sessions close as we go
+	var user string
+	var current *session
+	closeSession := func() {
+		if current == nil {
+			return
+		}
+		for _, w := range windows {
+			w.add(current)
+		}
+		current = nil
+	}
+	for cursor.Next(ctx) {
+		var step funnelStep
+		if err := cursor.Decode(&step); err != nil {
+			return fmt.Errorf("failed to decode funnel event: %w", err)
+		}
+		if step.UserID != user || (current != nil && step.At.Sub(current.last) > f.sessionGap) {
+			closeSession()
+			user = step.UserID
+		}
+		if current == nil {
+			current = newSession(step.At)
+		}
+		current.advance(step)
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to read funnel events: %w", err)
+	}
+	closeSession()
+
+	return f.store(ctx, windows, now)
+}
+
+// stepsPipeline reads tracked funnel events and order-service purchases since
+// since, sorted by user and time
+func (f *Funnels) stepsPipeline(since time.Time) mongo.Pipeline {
+	purchases := bson.A{
+		bson.M{"$match": bson.M{"createdAt": bson.M{"$gte": since}}},
+		bson.M{"$lookup": bson.M{
+			"from":         f.db.Orders().Name(),
+			"localField":   "orderId",
+			"foreignField": "_id",
+			"as":           "order",
+		}},
+		bson.M{"$unwind": "$order"},
+		bson.M{"$project": bson.M{
+			"_id":       0,
+			"userId":    bson.M{"$toString": "$order.userId"},
+			"productId": 1,
+			"shopId":    bson.M{"$toString": "$order.shopId"},
+			"step":      bson.M{"$literal": stepPurchase},
+			"at":        "$createdAt",
+		}},
+	}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"at": bson.M{"$gte": since}}}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "userId": 1, "productId": 1, "shopId": 1, "step": 1, "at": 1}}},
+		{{Key: "$unionWith", Value: bson.M{"coll": f.db.OrderItems().Name(), "pipeline": purchases}}},
+		{{Key: "$sort", Value: bson.D{{Key: "userId", Value: 1}, {Key: "at", Value: 1}}}},
+	}
+}
+
+// store upserts one productFunnels document per window and product or shop,
+// then removes the ones this run didn't produce
+func (f *Funnels) store(ctx context.Context, windows []*windowCounts, now time.Time) error {
+	var models []mongo.WriteModel
+	produced := make(map[string]map[string]bool, len(windows)) // Window -> funnelKey
+	for _, w := range windows {
+		produced[w.label] = make(map[string]bool, len(w.products)+len(w.shops))
+		for productID, counts := range w.products {
+			models = append(models, funnelModel(w, counts, objectID(productID), now))
+			produced[w.label][funnelKey(funnelShopID(counts), objectID(productID))] = true
+		}
+		for _, counts := range w.shops {
+			models = append(models, funnelModel(w, counts, nil, now))
+			produced[w.label][funnelKey(funnelShopID(counts), nil)] = true
+		}
+	}
+
+	if len(models) > 0 {
+		_, err := f.db.ProductFunnels().BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return fmt.Errorf("failed to store funnels: %w", err)
+		}
+	}
+
+	labels := make(bson.A, 0, len(windows))
+	for _, w := range windows {
+		labels = append(labels, w.label)
+		if err := f.removeStale(ctx, w.label, produced[w.label], now); err != nil {
+			return err
+		}
+	}
+
+	// Windows that are no longer configured
+	filter := bson.M{"window": bson.M{"$nin": labels}, "computedAt": bson.M{"$lt": now}}
+	if _, err := f.db.ProductFunnels().DeleteMany(ctx, filter); err != nil {
+		return fmt.Errorf("failed to remove stale funnels: %w", err)
+	}
+	return nil
+}
+
+// removeStale deletes the window's documents this run didn't produce. Another
+// replica may be storing at the same time, so documents computed after this
+// run started are left alone, and only stale ones are deleted by _id rather
+// than everything older than now.
+func (f *Funnels) removeStale(ctx context.Context, window string, produced map[string]bool, now time.Time) error {
+	filter := bson.M{"window": window, "computedAt": bson.M{"$lt": now}}
+	projection := bson.M{"_id": 1, "shopId": 1, "productId": 1}
+	cursor, err := f.db.ProductFunnels().Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		return fmt.Errorf("failed to find stale funnels: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var stale bson.A
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID        interface{} `bson:"_id"`
+			ShopID    interface{} `bson:"shopId"`
+			ProductID interface{} `bson:"productId"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("failed to decode funnel: %w", err)
+		}
+		if !produced[funnelKey(doc.ShopID, doc.ProductID)] {
+			stale = append(stale, doc.ID)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to find stale funnels: %w", err)
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	// computedAt again, in case another run refreshed one in the meantime
+	filter["_id"] = bson.M{"$in": stale}
+	if _, err := f.db.ProductFunnels().DeleteMany(ctx, filter); err != nil {
+		return fmt.Errorf("failed to remove stale funnels: %w", err)
+	}
+	return nil
+}
+
+// funnelModel upserts a funnel document. Shop funnels have no productId.
+func funnelModel(w *windowCounts, counts *funnelCounts, productID interface{}, now time.Time) mongo.WriteModel {
+	shopID := funnelShopID(counts)
+	filter := bson.M{"shopId": shopID, "productId": productID, "window": w.label}
+	update := bson.M{"$set": bson.M{
+		"windowStart":      w.start,
+		"windowEnd":        now,
+		"viewSessions":     counts.viewSessions,
+		"cartSessions":     counts.cartSessions,
+		"wishlistSessions": counts.wishlistSessions,
+		"purchaseSessions": counts.purchaseSessions,
+		"viewToCart":       rate(counts.cartSessions, counts.viewSessions),
+		"cartToPurchase":   rate(counts.purchaseSessions, counts.cartSessions),
+		"viewToPurchase":   rate(counts.purchaseSessions, counts.viewSessions),
+		"viewToWishlist":   rate(counts.wishlistSessions, counts.viewSessions),
+		"dropOffAfterView": dropOff(counts.cartSessions, counts.viewSessions),
+		"dropOffAfterCart": dropOff(counts.purchaseSessions, counts.cartSessions),
+		"computedAt":       now,
+	}}
+	return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true)
+}
+
+func funnelShopID(counts *funnelCounts) interface{} {
+	if counts.shopID == "" {
+		return nil
+	}
+	return objectID(counts.shopID)
+}
+
+// funnelKey identifies a funnel document within its window
+func funnelKey(shopID, productID interface{}) string {
+	return fmt.Sprint(shopID) + "|" + fmt.Sprint(productID)
+}
+
+func rate(reached, from int) float64 {
+	if from == 0 {
+		return 0
+	}
+	return float64(reached) / float64(from)
+}
+
+func dropOff(reached, from int) float64 {
+	if from == 0 {
+		return 0
+	}
+	return 1 - rate(reached, from)
+}
+
+// windowLabel names a window, e.g. 7d or 12h
+func windowLabel(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	default:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Counters kept per product in the hourly and daily buckets
+var bucketCounters = []string{"views", "cartAdds", "wishlistAdds", "purchases"}
+
+// hourlyGroup is the events of one product that fall in the same hour
+type hourlyGroup struct {
+	hour   time.Time
+	events []Event
+}
+
+// groupByHour splits a product's events by the hour they happened in
+func groupByHour(events []Event) []*hourlyGroup {
+	var groups []*hourlyGroup
+	byHour := map[time.Time]*hourlyGroup{}
+	for _, event := range events {
+		hour := eventTime(event).Truncate(time.Hour)
+		group, ok := byHour[hour]
+		if !ok {
+			group = &hourlyGroup{hour: hour}
+			byHour[hour] = group
+			groups = append(groups, group)
+		}
+		group.events = append(group.events, event)
+	}
+	return groups
+}
+
+// eventTime is when the event happened, as stated by its producer
+func eventTime(event Event) time.Time {
+	if event.Timestamp.IsZero() {
+		return time.Now().UTC()
+	}
+	return event.Timestamp.UTC()
+}
+
+// hourlyUpdate builds the upsert adding events to a product's bucket for
+// hour. It is a pipeline so users can be merged as a set and counted in the
+// same atomic update, and guarded on the event IDs like the lifetime counters.
+// Event values are wrapped in $literal so they can't be read as field paths.
+func hourlyUpdate(productID, shopID string, hour time.Time, events []Event, retention time.Duration) (bson.M, bson.A) {
+	now := time.Now()
+	inc := bson.M{}
+	users := bson.A{}
+	ids := bson.A{}
+	seen := map[string]bool{}
+	for _, event := range events {
+		for field, delta := range productIncrement(event.Action) {
+			current, _ := inc[field].(int)
+			inc[field] = current + delta.(int)
+		}
+		if event.UserID != "" && !seen[event.UserID] {
+			seen[event.UserID] = true
+			users = append(users, event.UserID)
+		}
+		if event.ID != "" {
+			ids = append(ids, event.ID)
+		}
+	}
+
+	var shop interface{}
+	if shopID != "" {
+		shop = objectID(shopID)
+	}
+
+	stage := bson.M{
+		"shopId":    bson.M{"$ifNull": bson.A{"$shopId", bson.M{"$literal": shop}}},
+		"day":       hour.Truncate(24 * time.Hour),
+		"users":     bson.M{"$setUnion": bson.A{bson.M{"$ifNull": bson.A{"$users", bson.A{}}}, bson.M{"$literal": users}}},
+		"expiresAt": hour.Add(retention),
+		"createdAt": bson.M{"$ifNull": bson.A{"$createdAt", now}},
+		"updatedAt": now,
+	}
+	for _, field := range bucketCounters {
+		delta, _ := inc[field].(int)
+		stage[field] = bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$" + field, 0}}, delta}}
+	}
+
+	filter := bson.M{"productId": objectID(productID), "hour": hour}
+	if len(ids) > 0 {
+		filter["appliedEvents"] = bson.M{"$nin": ids}
+		applied := bson.M{"$ifNull": bson.A{"$appliedEvents", bson.A{}}}
+		stage["appliedEvents"] = bson.M{"$slice": bson.A{bson.M{"$concatArrays": bson.A{applied, bson.M{"$literal": ids}}}, -appliedEventsKept}}
+	}
+
+	return filter, bson.A{
+		bson.M{"$set": stage},
+		bson.M{"$set": bson.M{"uniqueUsers": bson.M{"$size": "$users"}}},
+	}
+}
@@ -0,0 +1,400 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/eshop/kafka-service-go/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Backoff between attempts at a flush Mongo rejected
+const (
+	flushInitialBackoff = time.Second
+	flushMaxBackoff     = 30 * time.Second
+)
+
+// How long Close keeps retrying the final flush. Events it gives up on are
+// not acknowledged and get redelivered.
+const closeFlushTimeout = 10 * time.Second
+
+// ProductCounters coalesces productAnalytics updates. Increments for the same
+// product are summed and written with one unordered BulkWrite every interval
+// or once maxEvents are queued, along with the product's hourly buckets and
+// funnel events. An event's done callback runs only after all of them are
+// persisted, so its offset isn't stored before that.
+type ProductCounters struct {
+	collection *mongo.Collection
+	hourly     *mongo.Collection
+	funnel     *mongo.Collection
+	opts       ProductCounterOptions
+
+	mu      sync.Mutex
+	pending map[string]*productBatch
+	queued  int
+
+	slots     chan struct{} // Bounds queued events; Add blocks while Mongo is behind
+	full      chan struct{}
+	flushMu   sync.Mutex    // One flush at a time
+	abandoned chan struct{} // Closed by Abandon until Resume
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// productBatch is the coalesced update for one product
+type productBatch struct {
+	productID string
+	shopID    string
+	inc       bson.M
+	events    []Event
+	ids       map[string]bool
+	done      []func()
+}
+
+// ProductCounterOptions configures NewProductCounters
+type ProductCounterOptions struct {
+	Interval        time.Duration // Flush at least this often
+	MaxEvents       int           // Flush once this many events are queued
+	HourlyRetention time.Duration // How long hourly buckets are kept
+	FunnelRetention time.Duration // How long funnel events are kept
+}
+
+func NewProductCounters(db *db.MongoDB, opts ProductCounterOptions) *ProductCounters {
+	p := &ProductCounters{
+		collection: db.ProductAnalytics(),
+		hourly:     db.ProductAnalyticsHourly(),
+		funnel:     db.FunnelEvents(),
+		opts:       opts,
+		pending:    make(map[string]*productBatch),
+		slots:      make(chan struct{}, 4*opts.MaxEvents),
+		full:       make(chan struct{}, 1),
+		abandoned:  make(chan struct{}),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Add queues event's product update. done is called once it's persisted.
+func (p *ProductCounters) Add(event Event, done func()) {
+	p.slots <- struct{}{}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	batch, ok := p.pending[event.ProductID]
+	if !ok {
+		batch = &productBatch{productID: event.ProductID, inc: bson.M{}, ids: map[string]bool{}}
+		p.pending[event.ProductID] = batch
+	}
+	// A duplicate of a queued event is persisted along with it
+	batch.done = append(batch.done, done)
+	if event.ID != "" && batch.ids[event.ID] {
+		<-p.slots
+		return
+	}
+	batch.ids[event.ID] = true
+
+	if batch.shopID == "" {
+		batch.shopID = event.ShopID
+	}
+	for field, delta := range productIncrement(event.Action) {
+		current, _ := batch.inc[field].(int)
+		batch.inc[field] = current + delta.(int)
+	}
+	batch.events = append(batch.events, event)
+
+	p.queued++
+	if p.queued >= p.opts.MaxEvents {
+		select {
+		case p.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *ProductCounters) run() {
+	defer close(p.stopped)
+
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		case <-p.full:
+		}
+		p.flush(p.stop)
+	}
+}
+
+// Flush writes everything queued and waits until it is persisted, or until
+// Close is called
+func (p *ProductCounters) Flush() {
+	p.flush(p.stop)
+}
+
+// Close stops the periodic flushes and writes what is left, giving up after
+// closeFlushTimeout. Events added afterwards are never acknowledged.
+func (p *ProductCounters) Close() {
+	close(p.stop)
+	<-p.stopped
+
+	giveUp := make(chan struct{})
+	timer := time.AfterFunc(closeFlushTimeout, func() { close(giveUp) })
+	defer timer.Stop()
+	p.flush(giveUp)
+}
+
+// Abandon makes flushes give up instead of retrying, until Resume. Their events
+// are released unacknowledged, which frees Add callers blocked on a full
+// queue. A rebalance uses it so it can't hang on a Mongo outage; the events
+// get redelivered to the partitions' next owner.
+func (p *ProductCounters) Abandon() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.abandoned:
+	default:
+		close(p.abandoned)
+	}
+}
+
+// Resume lets flushes retry again after Abandon
+func (p *ProductCounters) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.abandoned:
+		p.abandoned = make(chan struct{})
+	default:
+	}
+}
+
+// flush writes the queued batches, retrying until they're persisted or
+// giveUp is closed or Abandon is called
+func (p *ProductCounters) flush(giveUp <-chan struct{}) {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	p.mu.Lock()
+	batches := make([]*productBatch, 0, len(p.pending))
+	for _, batch := range p.pending {
+		batches = append(batches, batch)
+	}
+	p.pending = make(map[string]*productBatch)
+	p.queued = 0
+	abandoned := p.abandoned
+	p.mu.Unlock()
+
+	backoff := flushInitialBackoff
+	for len(batches) > 0 {
+		var err error
+		batches, err = p.write(batches)
+		if err == nil {
+			return
+		}
+		log.Printf("Error flushing %d product analytics updates, retrying in %s: %v", len(batches), backoff, err)
+
+		select {
+		case <-giveUp:
+		case <-abandoned:
+		case <-time.After(backoff):
+			backoff = min(backoff*2, flushMaxBackoff)
+			continue
+		}
+		log.Printf("Gave up flushing %d product analytics updates, they will be redelivered", len(batches))
+		for _, batch := range batches {
+			p.release(batch)
+		}
+		return
+	}
+}
+
+// write sends one lifetime update per batch, one bucket update per hour the
+// batch covers and its funnel events, then acknowledges the batches persisted
+// in full. It returns the batches to retry; the guards make retrying the
+// parts that did succeed a no-op.
+func (p *ProductCounters) write(batches []*productBatch) ([]*productBatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lifetime := make([]mongo.WriteModel, len(batches))
+	var hourly []mongo.WriteModel
+	var groups []*hourlyGroup
+	var groupBatch []int // Index of the batch each hourly group came from
+	var funnel []mongo.WriteModel
+	var funnelEvents []Event
+	var funnelBatch []int
+	for i, batch := range batches {
+		ids := make(bson.A, 0, len(batch.events))
+		for _, event := range batch.events {
+			if event.ID != "" {
+				ids = append(ids, event.ID)
+			}
+		}
+		filter, update := productUpdate(batch.productID, batch.shopID, batch.inc)
+		guardAll(filter, update, ids)
+		lifetime[i] = mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true)
+
+		for _, group := range groupByHour(batch.events) {
+			filter, pipeline := hourlyUpdate(batch.productID, batch.shopID, group.hour, group.events, p.opts.HourlyRetention)
+			hourly = append(hourly, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(pipeline).SetUpsert(true))
+			groups = append(groups, group)
+			groupBatch = append(groupBatch, i)
+		}
+
+		for _, event := range batch.events {
+			if document := funnelEvent(event, p.opts.FunnelRetention); document != nil {
+				funnel = append(funnel, mongo.NewInsertOneModel().SetDocument(document))
+				funnelEvents = append(funnelEvents, event)
+				funnelBatch = append(funnelBatch, i)
+			}
+		}
+	}
+
+	failed, err := bulkWrite(ctx, p.collection, lifetime, func(i int) error {
+		return p.writeEach(ctx, batches[i])
+	})
+	if failed == nil {
+		return batches, err
+	}
+
+	hourlyFailed, hourlyErr := bulkWrite(ctx, p.hourly, hourly, func(i int) error {
+		return p.writeHourlyEach(ctx, batches[groupBatch[i]], groups[i])
+	})
+	if hourlyFailed == nil {
+		return batches, hourlyErr
+	}
+	for i := range hourlyFailed {
+		failed[groupBatch[i]] = true
+	}
+	if err == nil {
+		err = hourlyErr
+	}
+
+	funnelFailed, funnelErr := bulkWrite(ctx, p.funnel, funnel, func(i int) error {
+		return p.writeFunnelEvent(ctx, funnelEvents[i])
+	})
+	if funnelFailed == nil {
+		return batches, funnelErr
+	}
+	for i := range funnelFailed {
+		failed[funnelBatch[i]] = true
+	}
+	if err == nil {
+		err = funnelErr
+	}
+
+	var retry []*productBatch
+	for i, batch := range batches {
+		if failed[i] {
+			retry = append(retry, batch)
+			continue
+		}
+		p.acknowledge(batch)
+	}
+	if len(retry) > 0 {
+		return retry, err
+	}
+	return nil, nil
+}
+
+// bulkWrite runs models unordered. Models the server rejected get another go
+// through fallback: a guard rejects a coalesced update when any of its events
+// was already applied, and a concurrent insert fails the same way, which
+// applying the events one by one sorts out. It returns the models that still
+// failed, or nil if nothing is known to be persisted.
+func bulkWrite(ctx context.Context, collection *mongo.Collection, models []mongo.WriteModel, fallback func(i int) error) (map[int]bool, error) {
+	failed := map[int]bool{}
+	if len(models) == 0 {
+		return failed, nil
+	}
+
+	_, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	var writeErr mongo.BulkWriteException
+	switch {
+	case err == nil:
+		return failed, nil
+	case errors.As(err, &writeErr) && writeErr.WriteConcernError == nil:
+		for _, failure := range writeErr.WriteErrors {
+			if fallbackErr := fallback(failure.Index); fallbackErr != nil {
+				failed[failure.Index] = true
+				err = fallbackErr
+			}
+		}
+		if len(failed) == 0 {
+			return failed, nil
+		}
+		return failed, err
+	default:
+		return nil, err
+	}
+}
+
+// writeEach applies a batch's events individually
+func (p *ProductCounters) writeEach(ctx context.Context, batch *productBatch) error {
+	for _, event := range batch.events {
+		filter, update := productUpdate(batch.productID, batch.shopID, productIncrement(event.Action))
+		idempotent(filter, update, event.ID)
+		if err := guardedUpsert(ctx, p.collection, filter, update, options.Update().SetUpsert(true)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHourlyEach applies an hourly group's events individually
+func (p *ProductCounters) writeHourlyEach(ctx context.Context, batch *productBatch, group *hourlyGroup) error {
+	for _, event := range group.events {
+		filter, pipeline := hourlyUpdate(batch.productID, batch.shopID, group.hour, []Event{event}, p.opts.HourlyRetention)
+		if err := guardedUpsert(ctx, p.hourly, filter, pipeline, options.Update().SetUpsert(true)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFunnelEvent inserts one funnel event. It is keyed by the event ID, so a
+// duplicate key means it is already recorded.
+func (p *ProductCounters) writeFunnelEvent(ctx context.Context, event Event) error {
+	_, err := p.funnel.InsertOne(ctx, funnelEvent(event, p.opts.FunnelRetention))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (p *ProductCounters) acknowledge(batch *productBatch) {
+	for _, done := range batch.done {
+		done()
+	}
+	p.release(batch)
+}
+
+// release frees the batch's queue slots
+func (p *ProductCounters) release(batch *productBatch) {
+	for range batch.events {
+		<-p.slots
+	}
+}
+
+// guardAll is idempotent for a batch of events: the update only applies if
+// the document has none of them yet
+func guardAll(filter, update bson.M, ids bson.A) {
+	if len(ids) == 0 {
+		return
+	}
+	filter["appliedEvents"] = bson.M{"$nin": ids}
+	update["$push"] = bson.M{"appliedEvents": bson.M{"$each": ids, "$slice": -appliedEventsKept}}
+}
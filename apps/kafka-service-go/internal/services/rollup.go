@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/eshop/kafka-service-go/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Rollup compacts hourly product buckets into daily product and shop buckets.
+// Each run recomputes every day within lookback from scratch, so runs are
+// idempotent and several instances may run at once. Hourly buckets must be
+// retained for longer than lookback, or days would be recomputed from partial
+// data.
+type Rollup struct {
+	db       *db.MongoDB
+	interval time.Duration
+	lookback time.Duration
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func NewRollup(db *db.MongoDB, interval, lookback time.Duration) *Rollup {
+	return &Rollup{
+		db:       db,
+		interval: interval,
+		lookback: lookback,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Start compacts now and then every interval in the background
+func (r *Rollup) Start() {
+	go func() {
+		defer close(r.stopped)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			r.run()
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the periodic compaction and waits for a running one
+func (r *Rollup) Close() {
+	close(r.stop)
+	<-r.stopped
+}
+
+func (r *Rollup) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), r.interval)
+	defer cancel()
+
+	since := time.Now().UTC().Add(-r.lookback).Truncate(24 * time.Hour)
+	started := time.Now()
+	if err := r.Compact(ctx, since); err != nil {
+		log.Printf("Error compacting analytics since %s: %v", since.Format(time.DateOnly), err)
+		return
+	}
+	log.Printf("Compacted analytics since %s in %s", since.Format(time.DateOnly), time.Since(started).Round(time.Millisecond))
+}
+
+// Compact rebuilds the daily buckets of every day from since on
+func (r *Rollup) Compact(ctx context.Context, since time.Time) error {
+	opts := options.Aggregate().SetAllowDiskUse(true)
+
+	_, err := r.db.ProductAnalyticsHourly().Aggregate(ctx, dailyPipeline(since, "productId", r.db.ProductAnalyticsDaily().Name()), opts)
+	if err != nil {
+		return fmt.Errorf("failed to roll up product buckets: %w", err)
+	}
+
+	_, err = r.db.ProductAnalyticsHourly().Aggregate(ctx, dailyPipeline(since, "shopId", r.db.ShopAnalyticsDaily().Name()), opts)
+	if err != nil {
+		return fmt.Errorf("failed to roll up shop buckets: %w", err)
+	}
+	return nil
+}
+
+// dailyPipeline sums the hourly buckets from since per key and day and merges
+// the totals into the into collection. Unique users are counted across the
+// day's hours, not summed.
+func dailyPipeline(since time.Time, key, into string) mongo.Pipeline {
+	group := bson.M{
+		"_id":   bson.M{key: "$" + key, "day": "$day"},
+		"users": bson.M{"$push": "$users"},
+	}
+	project := bson.M{
+		"_id": 0,
+		key:   "$_id." + key,
+		"day": "$_id.day",
+		"uniqueUsers": bson.M{"$size": bson.M{"$reduce": bson.M{
+			"input":        "$users",
+			"initialValue": bson.A{},
+			"in":           bson.M{"$setUnion": bson.A{"$$value", "$$this"}},
+		}}},
+		"updatedAt": "$$NOW",
+	}
+	for _, field := range bucketCounters {
+		group[field] = bson.M{"$sum": "$" + field}
+		project[field] = 1
+	}
+	if key == "productId" {
+		group["shopId"] = bson.M{"$first": "$shopId"}
+		project["shopId"] = 1
+	}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"day": bson.M{"$gte": since}, key: bson.M{"$ne": nil}}}},
+		{{Key: "$group", Value: group}},
+		{{Key: "$project", Value: project}},
+		{{Key: "$merge", Value: bson.M{
+			"into":           into,
+			"on":             bson.A{key, "day"},
+			"whenMatched":    "merge",
+			"whenNotMatched": "insert",
+		}}},
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UpdateShopAnalytics records a shop visit. Each user counts once per shop:
+// totalVisitors and the country/city/device counters only move for the event
+// that first recorded the visitor. That event keeps counting as the first
+// visit when it is redelivered, so an increment that failed is retried, and
+// the appliedEvents guard stops one that succeeded from being applied twice.
+func (s *AnalyticsService) UpdateShopAnalytics(ctx context.Context, event Event) error {
+	if event.ShopID == "" || event.UserID == "" {
+		// Without a user there is nothing to deduplicate on
+		return nil
+	}
+
+	firstVisit, err := s.recordShopVisitor(ctx, event)
+	if err != nil {
+		log.Printf("Error recording shop visitor: %v", err)
+		return err
+	}
+
+	now := time.Now()
+	filter := bson.M{"shopId": objectID(event.ShopID)}
+	update := bson.M{
+		"$set": bson.M{
+			"lastVisitedAt": now,
+			"updatedAt":     now,
+		},
+		"$setOnInsert": bson.M{
+			"createdAt": now,
+			"shopId":    objectID(event.ShopID),
+		},
+	}
+
+	if firstVisit {
+		inc := bson.M{"totalVisitors": 1}
+		if key := statsKey(event.Country); key != "" {
+			inc["countryStats."+key] = 1
+		}
+		if key := statsKey(event.City); key != "" {
+			inc["cityStats."+key] = 1
+		}
+		if key := statsKey(event.Device); key != "" {
+			inc["deviceStats."+key] = 1
+		}
+		update["$inc"] = inc
+		idempotent(filter, update, event.ID)
+		err = guardedUpsert(ctx, s.db.ShopAnalytics(), filter, update, options.Update().SetUpsert(true))
+	} else {
+		update["$setOnInsert"].(bson.M)["totalVisitors"] = 0
+		_, err = s.db.ShopAnalytics().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	}
+	if err != nil {
+		log.Printf("Error updating shop analytics: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// recordShopVisitor upserts the (shopId, userId) pair and reports whether
+// event is the one that first recorded it, which stays true on redelivery
+func (s *AnalyticsService) recordShopVisitor(ctx context.Context, event Event) (bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"shopId": objectID(event.ShopID),
+		"userId": objectID(event.UserID),
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"visitedAt": now,
+			"updatedAt": now,
+		},
+		"$setOnInsert": bson.M{
+			"firstEventId": event.ID,
+			"createdAt":    now,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var visitor struct {
+		FirstEventID string `bson:"firstEventId"`
+	}
+	err := s.db.UniqueShopVisitors().FindOneAndUpdate(ctx, filter, update, opts).Decode(&visitor)
+	if mongo.IsDuplicateKeyError(err) {
+		// Two events for the same new visitor raced and the other one inserted first
+		err = s.db.UniqueShopVisitors().FindOneAndUpdate(ctx, filter, update, opts).Decode(&visitor)
+	}
+	if err != nil {
+		return false, err
+	}
+	return event.ID != "" && visitor.FirstEventID == event.ID, nil
+}
+
+// objectID stores IDs the way Prisma's @db.ObjectId fields expect them, falling
+// back to the raw string for IDs that aren't ObjectIds
+func objectID(id string) interface{} {
+	if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+		return oid
+	}
+	return id
+}
+
+// statsKey makes a value safe to use as a document field name. Mongo treats
+// dots as path separators and a leading $ as an operator.
+func statsKey(value string) string {
+	value = strings.TrimSpace(value)
+	value = strings.ReplaceAll(value, ".", "_")
+	return strings.TrimLeft(value, "$")
+}
@@ -46,7 +46,9 @@ export const consumeKafkaMessages = async () => {
   await consumer.run({
     eachMessage: async ({ message }) => {
       if (!message.value) return;
-      const event = JSON.parse(message.value.toString());
+      const payload = JSON.parse(message.value.toString());
+      // v1 events are wrapped in an envelope, v0 events are the bare payload
+      const event = payload.schemaVersion ? payload.data : payload;
       eventsQueue.push(event);
     },
   });
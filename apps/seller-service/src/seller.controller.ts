@@ -3,6 +3,17 @@ import imageKit from '@packages/libs/imageKit';
 import prisma from '@packages/libs/prisma';
 import { NextFunction, Response, Request } from 'express';
 
+// Prisma throws on malformed ObjectIds, check query params up front
+const isObjectId = (value: unknown): value is string =>
+  typeof value === 'string' && /^[0-9a-f]{24}$/i.test(value);
+
+// Funnel windows computed by kafka-service-go, labelled as it labels its
+// FUNNEL_WINDOWS
+const funnelWindows = (process.env.FUNNEL_WINDOW_LABELS || '1d,7d,30d')
+  .split(',')
+  .map((label) => label.trim())
+  .filter(Boolean);
+
 export const getSellerNotifications = async (
   req: any,
   res: Response,
@@ -207,3 +218,135 @@ export const isFollowing = async (req, res) => {
 };
 
 
+
+// Get view/cart/wishlist/purchase trends for the seller's shop, or one of its
+// products. Daily buckets by default, hourly (last few days only) with
+// granularity=hour.
+export const getAnalyticsTrends = async (
+  req: any,
+  res: Response,
+  next: NextFunction
+) => {
+  try {
+    const { productId, granularity = 'day' } = req.query;
+    if (granularity !== 'day' && granularity !== 'hour') {
+      return next(new ValidationError('granularity must be day or hour'));
+    }
+    if (productId && !isObjectId(productId)) {
+      return next(new ValidationError('productId must be a valid product ID'));
+    }
+
+    const shop = await prisma.shops.findUnique({
+      where: { sellerId: req.seller.id },
+    });
+    if (!shop) {
+      return next(new ValidationError('Shop not found'));
+    }
+
+    if (productId) {
+      const product = await prisma.products.findFirst({
+        where: { id: productId, shopId: shop.id },
+      });
+      if (!product) {
+        return next(new ValidationError('Product not found'));
+      }
+    }
+
+    const counters = {
+      views: true,
+      cartAdds: true,
+      wishlistAdds: true,
+      purchases: true,
+    };
+
+    if (granularity === 'hour') {
+      const hours = Math.min(parseInt(req.query.hours as string) || 48, 168);
+      const since = new Date(Date.now() - hours * 60 * 60 * 1000);
+
+      // Unique users can't be summed across products, so shop-wide hourly
+      // trends only carry the counters
+      const trends = productId
+        ? await prisma.productAnalyticsHourly.findMany({
+            where: { productId, hour: { gte: since } },
+            select: { hour: true, uniqueUsers: true, ...counters },
+            orderBy: { hour: 'asc' },
+          })
+        : (
+            await prisma.productAnalyticsHourly.groupBy({
+              by: ['hour'],
+              where: { shopId: shop.id, hour: { gte: since } },
+              _sum: counters,
+              orderBy: { hour: 'asc' },
+            })
+          ).map(({ hour, _sum }) => ({ hour, ..._sum }));
+
+      return res.status(200).json({ success: true, trends });
+    }
+
+    const days = Math.min(parseInt(req.query.days as string) || 30, 365);
+    const since = new Date(Date.now() - days * 24 * 60 * 60 * 1000);
+
+    const trends = productId
+      ? await prisma.productAnalyticsDaily.findMany({
+          where: { productId, day: { gte: since } },
+          select: { day: true, uniqueUsers: true, ...counters },
+          orderBy: { day: 'asc' },
+        })
+      : await prisma.shopAnalyticsDaily.findMany({
+          where: { shopId: shop.id, day: { gte: since } },
+          select: { day: true, uniqueUsers: true, ...counters },
+          orderBy: { day: 'asc' },
+        });
+
+    return res.status(200).json({ success: true, trends });
+  } catch (error) {
+    return next(error);
+  }
+};
+
+// Get conversion funnels for the seller's shop and its products over a window
+// (1d, 7d or 30d by default), best viewed products first
+export const getFunnels = async (
+  req: any,
+  res: Response,
+  next: NextFunction
+) => {
+  try {
+    const { window = '7d', productId } = req.query;
+    const limit = Math.min(parseInt(req.query.limit as string) || 20, 100);
+    if (!funnelWindows.includes(window)) {
+      return next(
+        new ValidationError(`window must be one of ${funnelWindows.join(', ')}`)
+      );
+    }
+    if (productId && !isObjectId(productId)) {
+      return next(new ValidationError('productId must be a valid product ID'));
+    }
+
+    const shop = await prisma.shops.findUnique({
+      where: { sellerId: req.seller.id },
+    });
+    if (!shop) {
+      return next(new ValidationError('Shop not found'));
+    }
+
+    const [shopFunnel, products] = await Promise.all([
+      prisma.productFunnels.findFirst({
+        where: { shopId: shop.id, productId: null, window },
+      }),
+      prisma.productFunnels.findMany({
+        where: {
+          shopId: shop.id,
+          window,
+          productId: productId ? productId : { not: null },
+        },
+        orderBy: { viewSessions: 'desc' },
+        take: limit,
+      }),
+    ]);
+
+    return res.status(200).json({ success: true, shop: shopFunnel, products });
+  } catch (error) {
+    return next(error);
+  }
+};
@@ -1,7 +1,7 @@
 import { isSeller } from '@packages/error-handler/authorizeRoles';
 import isAuthenticated from '@packages/error-handler/isAuthenticated';
 import { Router } from 'express';
-import { followShop, getFollowerCount, getSellerNotifications, isFollowing, markAsRead, unfollowShop, updateShopInfo } from './seller.controller';
+import { followShop, getAnalyticsTrends, getFollowerCount, getFunnels, getSellerNotifications, isFollowing, markAsRead, unfollowShop, updateShopInfo } from './seller.controller';
 
 const router = Router();
 
@@ -12,6 +12,8 @@ router.get(
   getSellerNotifications
 );
 router.put('/mark-as-read/:notificationId', isAuthenticated, markAsRead);
+router.get('/analytics-trends', isAuthenticated, isSeller, getAnalyticsTrends);
+router.get('/funnels', isAuthenticated, isSeller, getFunnels);
 router.put(
   '/update-shop-info',
   isAuthenticated,
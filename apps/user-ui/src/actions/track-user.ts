@@ -1,10 +1,13 @@
 'use server';
 
+import { randomUUID } from 'crypto';
+import { headers } from 'next/headers';
 import kafka  from '@packages/utils/kafka';
 
 const producer = kafka.producer();
 
-export async function sendKafkaEvent(eventData: {
+export async function sendKafkaEvent({ eventId = randomUUID(), ...eventData }: {
+  eventId?: string; // Reused for a double click, so the consumer drops the repeat
   userId?: string;
   productId?: string;
   shopId?: string;
@@ -14,10 +17,27 @@ export async function sendKafkaEvent(eventData: {
   city?: string;
 }) {
   try {
+    // Set by the gateway on requests it flagged as automated, the analytics
+    // consumer drops events scored at or above its threshold
+    const botScore = Math.min(parseInt((await headers()).get('x-bot-score') ?? '', 10), 100);
+
     await producer.connect();
     await producer.send({
       topic: 'users-events',
-      messages: [{ value: JSON.stringify(eventData) }],
+      // v1 envelope, see apps/kafka-service-go/internal/events/schemas
+      messages: [
+        {
+          // Keeps a user's events on one partition, in order
+          key: eventData.userId,
+          value: JSON.stringify({
+            eventId,
+            schemaVersion: 1,
+            timestamp: new Date().toISOString(),
+            source: 'user-ui',
+            data: botScore > 0 ? { ...eventData, botScore } : eventData,
+          }),
+        },
+      ],
     });
   } catch (error) {
     console.log(error);
@@ -40,6 +40,21 @@ type Store = {
   ) => void;
 };
 
+// A double click sends the same event twice in quick succession. Both get one
+// eventId so the analytics consumer counts it once, while a real repeat, such
+// as add, remove and add again, gets a fresh one.
+const DOUBLE_CLICK_MS = 1000;
+let lastEvent: { key: string; eventId: string; at: number } | null = null;
+
+const clickEventId = (action: string, productId: string) => {
+  const key = `${action}:${productId}`;
+  const now = Date.now();
+  if (lastEvent?.key !== key || now - lastEvent.at > DOUBLE_CLICK_MS) {
+    lastEvent = { key, eventId: crypto.randomUUID(), at: now };
+  }
+  return lastEvent.eventId;
+};
+
 export const useStore = create<Store>()(
   persist(
     (set, get) => ({
@@ -65,6 +80,7 @@ export const useStore = create<Store>()(
         // Send kafka event
         if (user?.id && location && deviceInfo) {
           sendKafkaEvent({
+            eventId: clickEventId('add_to_cart', product.id),
             userId: user?.id,
             productId: product?.id,
             shopId: product?.shopId,
@@ -84,6 +100,7 @@ export const useStore = create<Store>()(
         }));
         if (user?.id && location && deviceInfo && productToRemove) {
           sendKafkaEvent({
+            eventId: clickEventId('remove_from_cart', productToRemove.id),
             userId: user?.id,
             productId: productToRemove?.id,
             shopId: productToRemove?.shopId,
@@ -101,6 +118,7 @@ export const useStore = create<Store>()(
         }));
         if (user?.id && location && deviceInfo) {
           sendKafkaEvent({
+            eventId: clickEventId('add_to_wishlist', product.id),
             userId: user?.id,
             productId: product?.id,
             shopId: product?.shopId,
@@ -120,6 +138,7 @@ export const useStore = create<Store>()(
         }));
         if (user?.id && location && deviceInfo && productToRemove) {
           sendKafkaEvent({
+            eventId: clickEventId('remove_from_wishlist', productToRemove.id),
             userId: user?.id,
             productId: productToRemove?.id,
             shopId: productToRemove?.shopId,